@@ -0,0 +1,309 @@
+package promnats
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/nats-io/nuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// HeaderAuthKey is the public user nkey of the requester
+	HeaderAuthKey = "Promnats-Auth-Key"
+	// HeaderAuthTime is the unix time the request was signed
+	HeaderAuthTime = "Promnats-Auth-Time"
+	// HeaderAuthNonce is unique for every signed request
+	HeaderAuthNonce = "Promnats-Auth-Nonce"
+	// HeaderAuthSignature is the base64 encoded signature of the subject, reply subject,
+	// HeaderAuthTime and HeaderAuthNonce
+	HeaderAuthSignature = "Promnats-Auth-Signature"
+
+	// maxAuthSkew is how old, or far in the future, a signature may be
+	maxAuthSkew = time.Minute
+	// maxRequesters is the number of requesters tracked before the idlest is removed
+	maxRequesters = 10000
+	// maxNonces is the number of nonces remembered before the oldest is removed
+	maxNonces = 100000
+)
+
+// metAuthRequests counts the checked requests of all handlers using an authorization option
+var metAuthRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "promnats_requests_total",
+	Help: "Number of requests checked by the authorization policy, partitioned by connection and result.",
+}, []string{"conn", "result"})
+
+// authPolicy is checked before gathering
+type authPolicy struct {
+	keys     map[string]bool
+	prefixes []string
+	limiter  *requesterLimiter
+	// nonces are the nonces of accepted signatures
+	nonces *nonceCache
+}
+
+func (o *options) authPolicy() *authPolicy {
+	if o.auth == nil {
+		o.auth = &authPolicy{}
+	}
+	return o.auth
+}
+
+// WithAllowedRequesters only answers requests signed, using SignRequest,
+// by one of the public user nkeys in keys.
+func WithAllowedRequesters(keys ...string) Option {
+	return func(o *options) error {
+		p := o.authPolicy()
+		if p.keys == nil {
+			p.keys = make(map[string]bool)
+			p.nonces = &nonceCache{seen: map[string]time.Time{}}
+		}
+		for _, k := range keys {
+			if !nkeys.IsValidPublicUserKey(k) {
+				return fmt.Errorf("invalid public user nkey '%s'", k)
+			}
+			p.keys[k] = true
+		}
+		return nil
+	}
+}
+
+// WithAllowedReplyPrefixes only answers requests with a reply subject starting
+// with one of prefixes, like "_INBOX.gateway.".
+func WithAllowedReplyPrefixes(prefixes ...string) Option {
+	return func(o *options) error {
+		for _, p := range prefixes {
+			if p == "" {
+				return errors.New("reply prefix must not be empty")
+			}
+		}
+		o.authPolicy().prefixes = append(o.authPolicy().prefixes, prefixes...)
+		return nil
+	}
+}
+
+// WithRequesterRateLimit allows each requester rate requests per second with bursts of burst.
+// With WithAllowedRequesters every key has its own limit, otherwise all requests share one.
+func WithRequesterRateLimit(rate float64, burst int) Option {
+	return func(o *options) error {
+		if rate <= 0 || burst < 1 {
+			return errors.New("rate and burst must be positive")
+		}
+		o.authPolicy().limiter = &requesterLimiter{rate: rate, burst: float64(burst), buckets: map[string]*tokenBucket{}}
+		return nil
+	}
+}
+
+// SignRequest signs msg with the user nkey kp for instances using WithAllowedRequesters.
+// The reply subject is signed, so set it before signing and publish msg with
+// nc.PublishMsg, nc.RequestMsg replaces it.
+func SignRequest(msg *nats.Msg, kp nkeys.KeyPair) error {
+	pub, err := kp.PublicKey()
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := nuid.Next()
+	sig, err := kp.Sign(signedData(msg.Subject, msg.Reply, ts, nonce))
+	if err != nil {
+		return err
+	}
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	msg.Header.Set(HeaderAuthKey, pub)
+	msg.Header.Set(HeaderAuthTime, ts)
+	msg.Header.Set(HeaderAuthNonce, nonce)
+	msg.Header.Set(HeaderAuthSignature, encodeSig(sig))
+	return nil
+}
+
+// encodeSig encodes a signature for HeaderAuthSignature
+func encodeSig(sig []byte) string {
+	return base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func signedData(subject, reply, ts, nonce string) []byte {
+	return []byte(subject + "\n" + reply + "\n" + ts + "\n" + nonce)
+}
+
+// check returns a status error if msg, received on the connection named conn, is not allowed
+func (p *authPolicy) check(msg *nats.Msg, conn string) *statusError {
+	if err := p.verify(msg); err != nil {
+		metAuthRequests.WithLabelValues(conn, "forbidden").Inc()
+		return &statusError{code: 403, description: "forbidden: " + err.Error()}
+	}
+	// only verified keys tell requesters apart, anything else can be made up
+	requester := ""
+	if len(p.keys) > 0 {
+		requester = msg.Header.Get(HeaderAuthKey)
+	}
+	if p.limiter != nil && !p.limiter.allow(requester, time.Now()) {
+		metAuthRequests.WithLabelValues(conn, "rate_limited").Inc()
+		return &statusError{code: 429, description: "too many requests"}
+	}
+	metAuthRequests.WithLabelValues(conn, "allowed").Inc()
+	return nil
+}
+
+func (p *authPolicy) verify(msg *nats.Msg) error {
+	if len(p.prefixes) > 0 {
+		ok := false
+		for _, prefix := range p.prefixes {
+			if strings.HasPrefix(msg.Reply, prefix) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return errors.New("reply subject not allowed")
+		}
+	}
+	if len(p.keys) == 0 {
+		return nil
+	}
+	key := msg.Header.Get(HeaderAuthKey)
+	if !p.keys[key] {
+		return errors.New("requester not allowed")
+	}
+	ts := msg.Header.Get(HeaderAuthTime)
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("invalid signature time")
+	}
+	signed := time.Unix(unix, 0)
+	if d := time.Since(signed); d > maxAuthSkew || d < -maxAuthSkew {
+		return errors.New("signature expired")
+	}
+	nonce := msg.Header.Get(HeaderAuthNonce)
+	if nonce == "" {
+		return errors.New("missing nonce")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(msg.Header.Get(HeaderAuthSignature))
+	if err != nil {
+		return errors.New("invalid signature")
+	}
+	pub, err := nkeys.FromPublicKey(key)
+	if err != nil {
+		return err
+	}
+	if err := pub.Verify(signedData(msg.Subject, msg.Reply, ts, nonce), sig); err != nil {
+		return errors.New("invalid signature")
+	}
+	// a signature is valid until maxAuthSkew after it was made
+	if !p.nonces.add(key+"."+nonce, signed.Add(maxAuthSkew), time.Now()) {
+		return errors.New("signature already used")
+	}
+	return nil
+}
+
+// nonceCache remembers nonces until their signature expires
+type nonceCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// add returns false if nonce was added before and has not expired
+func (c *nonceCache) add(nonce string, expires, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+	if len(c.seen) >= maxNonces {
+		c.prune(now)
+	}
+	c.seen[nonce] = expires
+	return true
+}
+
+// prune removes the expired nonces, or the oldest if none has expired. c.mu must be held.
+func (c *nonceCache) prune(now time.Time) {
+	oldest := ""
+	for nonce, expires := range c.seen {
+		if expires.Before(now) {
+			delete(c.seen, nonce)
+		} else if oldest == "" || expires.Before(c.seen[oldest]) {
+			oldest = nonce
+		}
+	}
+	if len(c.seen) >= maxNonces {
+		delete(c.seen, oldest)
+	}
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// requesterLimiter has a token bucket per requester
+type requesterLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+func (l *requesterLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRequesters {
+			l.prune(now)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune removes the buckets that are full again, or the one idle the longest
+// if none is. l.mu must be held.
+func (l *requesterLimiter) prune(now time.Time) {
+	idlest := ""
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		} else if idlest == "" || b.last.Before(l.buckets[idlest].last) {
+			idlest = key
+		}
+	}
+	if len(l.buckets) >= maxRequesters {
+		delete(l.buckets, idlest)
+	}
+}
+
+// authorize returns false if msg is not allowed by cfg. Requests on the subjects
+// of the ID are answered with a status and the ID only, that is already in the subject.
+// Requests on broadcast subjects are not answered, so no one learns who listens.
+func authorize(msg *nats.Msg, cfg *options, conn string) bool {
+	if cfg.auth == nil {
+		return true
+	}
+	se := cfg.auth.check(msg, conn)
+	if se == nil {
+		return true
+	}
+	own := cfg.subject(cfg.ID)
+	if msg.Subject == own || strings.HasPrefix(msg.Subject, own+".") {
+		hdr := nats.Header{}
+		hdr.Set(HeaderPnID, cfg.ID)
+		respondStatus(msg, hdr, se)
+	}
+	return false
+}
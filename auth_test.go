@@ -0,0 +1,234 @@
+package promnats
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+func Test_authPolicy_check(t *testing.T) {
+	allowed, _ := nkeys.CreateUser()
+	other, _ := nkeys.CreateUser()
+	pub, _ := allowed.PublicKey()
+
+	cfg := &options{}
+	if err := WithAllowedRequesters(pub)(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := WithAllowedReplyPrefixes("_INBOX.gw.")(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	signed := func(kp nkeys.KeyPair, reply string) *nats.Msg {
+		msg := nats.NewMsg("metrics.a")
+		msg.Reply = reply
+		if err := SignRequest(msg, kp); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	expired := signed(allowed, "_INBOX.gw.1")
+	ts := strconv.FormatInt(time.Now().Add(-2*maxAuthSkew).Unix(), 10)
+	sig, _ := allowed.Sign(signedData(expired.Subject, expired.Reply, ts, expired.Header.Get(HeaderAuthNonce)))
+	expired.Header.Set(HeaderAuthTime, ts)
+	expired.Header.Set(HeaderAuthSignature, encodeSig(sig))
+	tampered := signed(allowed, "_INBOX.gw.1")
+	tampered.Subject = "metrics.b"
+	otherReply := signed(allowed, "_INBOX.gw.1")
+	otherReply.Reply = "_INBOX.gw.2"
+	noNonce := signed(allowed, "_INBOX.gw.1")
+	noNonce.Header.Del(HeaderAuthNonce)
+	replayed := signed(allowed, "_INBOX.gw.1")
+
+	tests := []struct {
+		name string
+		msg  *nats.Msg
+		want int
+	}{
+		{"allowed", signed(allowed, "_INBOX.gw.1"), 0},
+		{"other key", signed(other, "_INBOX.gw.1"), 403},
+		{"unsigned", &nats.Msg{Subject: "metrics.a", Reply: "_INBOX.gw.1", Header: nats.Header{}}, 403},
+		{"reply prefix", signed(allowed, "_INBOX.other.1"), 403},
+		{"expired", expired, 403},
+		{"tampered", tampered, 403},
+		{"other reply", otherReply, 403},
+		{"no nonce", noNonce, 403},
+		{"first use", replayed, 0},
+		{"replayed", replayed, 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := 0
+			if se := cfg.auth.check(tt.msg, "0"); se != nil {
+				got = se.code
+			}
+			if got != tt.want {
+				t.Errorf("check() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_requesterLimiter(t *testing.T) {
+	l := &requesterLimiter{rate: 1, burst: 2, buckets: map[string]*tokenBucket{}}
+	now := time.Now()
+	for i, want := range []bool{true, true, false} {
+		if got := l.allow("a", now); got != want {
+			t.Errorf("allow() %d = %v, want %v", i, got, want)
+		}
+	}
+	if !l.allow("b", now) {
+		t.Error("other requester should have its own bucket")
+	}
+	if !l.allow("a", now.Add(time.Second)) {
+		t.Error("expected a token after a second")
+	}
+}
+
+func Test_requesterLimiter_max(t *testing.T) {
+	l := &requesterLimiter{rate: 1, burst: 2, buckets: map[string]*tokenBucket{}}
+	now := time.Now()
+	for i := 0; i < maxRequesters+10; i++ {
+		l.allow(strconv.Itoa(i), now.Add(time.Duration(i)*time.Microsecond))
+	}
+	if len(l.buckets) > maxRequesters {
+		t.Errorf("buckets = %d, want at most %d", len(l.buckets), maxRequesters)
+	}
+	if _, ok := l.buckets["0"]; ok {
+		t.Error("the idlest requester was not removed")
+	}
+}
+
+func Test_authPolicy_check_limit(t *testing.T) {
+	kp, _ := nkeys.CreateUser()
+	pub, _ := kp.PublicKey()
+	signed := func(reply string) *nats.Msg {
+		msg := nats.NewMsg("metrics.a")
+		msg.Reply = reply
+		if err := SignRequest(msg, kp); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	tests := []struct {
+		name string
+		opts []Option
+		msgs []*nats.Msg
+		want []int
+	}{
+		{"shared without keys", []Option{WithRequesterRateLimit(0.001, 1)}, []*nats.Msg{
+			{Subject: "metrics.a", Reply: "_INBOX.a.1", Header: nats.Header{HeaderAuthKey: {"UA"}}},
+			{Subject: "metrics.a", Reply: "_INBOX.b.1", Header: nats.Header{HeaderAuthKey: {"UB"}}},
+		}, []int{0, 429}},
+		{"by verified key", []Option{WithAllowedRequesters(pub), WithRequesterRateLimit(0.001, 1)}, []*nats.Msg{
+			signed("_INBOX.a.1"), signed("_INBOX.a.2"),
+		}, []int{0, 429}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &options{}
+			for _, o := range tt.opts {
+				if err := o(cfg); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i, msg := range tt.msgs {
+				got := 0
+				if se := cfg.auth.check(msg, "0"); se != nil {
+					got = se.code
+				}
+				if got != tt.want[i] {
+					t.Errorf("check() %d = %d, want %d", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_nonceCache(t *testing.T) {
+	c := &nonceCache{seen: map[string]time.Time{}}
+	now := time.Now()
+	if !c.add("a", now.Add(time.Minute), now) {
+		t.Error("add() of a new nonce = false")
+	}
+	if c.add("a", now.Add(time.Minute), now) {
+		t.Error("add() of a seen nonce = true")
+	}
+	for i := 0; i < maxNonces+10; i++ {
+		c.add(strconv.Itoa(i), now.Add(time.Minute+time.Duration(i)*time.Microsecond), now)
+	}
+	if len(c.seen) > maxNonces {
+		t.Errorf("nonces = %d, want at most %d", len(c.seen), maxNonces)
+	}
+}
+
+func TestHandler_authorize(t *testing.T) {
+	s := runServer(t)
+	kp, _ := nkeys.CreateUser()
+	pub, _ := kp.PublicKey()
+	h, err := NewHandler(connect(t, s), WithID("a.b"), WithMetadata(map[string]string{"team": "ops"}),
+		WithAllowedRequesters(pub), WithCollisionPolicy(CollisionIgnore, time.Second))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer h.Close()
+	client := connect(t, s)
+
+	tests := []struct {
+		name   string
+		msg    *nats.Msg
+		sign   bool
+		status string
+	}{
+		{"probe", &nats.Msg{Subject: "metrics.a.b", Header: nats.Header{HeaderProbe: {"other"}}}, false, "403"},
+		{"info", &nats.Msg{Subject: InfoSubject("metrics", "a.b")}, false, "403"},
+		{"signed info", &nats.Msg{Subject: InfoSubject("metrics", "a.b")}, true, ""},
+		{"discover", &nats.Msg{Subject: DiscoverSubject("metrics")}, false, "timeout"},
+		{"signed discover", &nats.Msg{Subject: DiscoverSubject("metrics")}, true, ""},
+		{"broadcast", &nats.Msg{Subject: "metrics.a"}, false, "timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, err := client.SubscribeSync(client.NewRespInbox())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Unsubscribe()
+			msg := tt.msg
+			if msg.Header == nil {
+				msg.Header = nats.Header{}
+			}
+			msg.Reply = sub.Subject
+			if tt.sign {
+				if err := SignRequest(msg, kp); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := client.PublishMsg(msg); err != nil {
+				t.Fatal(err)
+			}
+			resp, err := sub.NextMsg(200 * time.Millisecond)
+			if tt.status == "timeout" {
+				if err == nil {
+					t.Errorf("reply %v, want none", resp.Header)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("no reply: %v", err)
+			}
+			if got := resp.Header.Get(hdrStatus); got != tt.status {
+				t.Errorf("status = %q, want %q", got, tt.status)
+			}
+			if got := resp.Header.Get(HeaderPnID); got != "a.b" {
+				t.Errorf("reply %s = %q, want a.b", HeaderPnID, got)
+			}
+			if got := resp.Header.Get(labelHeader("team")); (got != "") != (tt.status == "") {
+				t.Errorf("reply metadata = %q with status %q", got, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package promnats
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// benchFormats are the formats a handler replies with, by Accept header
+var benchFormats = []struct {
+	name   string
+	accept string
+}{
+	{"text", "text/plain;version=0.0.4"},
+	{"protodelim", string(expfmt.NewFormat(expfmt.TypeProtoDelim))},
+	{"json", ContentTypeJSON},
+}
+
+// benchRegistry returns a registry with n series spread over 10 families
+func benchRegistry(n int) prometheus.TransactionalGatherer {
+	reg := prometheus.NewRegistry()
+	for f := 0; f < 10; f++ {
+		g := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("bench_family_%d", f),
+			Help: "Benchmark family.",
+		}, []string{"series"})
+		reg.MustRegister(g)
+		for i := 0; i < n/10; i++ {
+			g.WithLabelValues(fmt.Sprint(i)).Set(float64(i))
+		}
+	}
+	return prometheus.ToTransactionalGatherer(reg)
+}
+
+// benchScrapes runs b.N scrapes and reports the p99 latency
+func benchScrapes(b *testing.B, cfg *options, reg prometheus.TransactionalGatherer, accept string) {
+	req := nats.Header{}
+	req.Set(hdrAccept, accept)
+	durations := make([]time.Duration, b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		resp, buf, se := gatherReply(req, cfg, reg)
+		if se != nil {
+			b.Fatal(se)
+		}
+		b.SetBytes(int64(len(resp.Data)))
+		putBuffer(buf)
+		durations[i] = time.Since(start)
+	}
+	b.StopTimer()
+	slices.Sort(durations)
+	b.ReportMetric(float64(durations[(len(durations)-1)*99/100].Nanoseconds()), "p99-ns")
+}
+
+func BenchmarkGatherReply(b *testing.B) {
+	for _, n := range []int{100, 10_000, 100_000} {
+		reg := benchRegistry(n)
+		for _, f := range benchFormats {
+			b.Run(fmt.Sprintf("series=%d/%s", n, f.name), func(b *testing.B) {
+				benchScrapes(b, &options{Header: nats.Header{}}, reg, f.accept)
+			})
+		}
+	}
+}
+
+func BenchmarkGatherReplyCached(b *testing.B) {
+	for _, n := range []int{100, 10_000, 100_000} {
+		reg := newCachedGatherer(benchRegistry(n), time.Hour)
+		for _, f := range benchFormats {
+			b.Run(fmt.Sprintf("series=%d/%s", n, f.name), func(b *testing.B) {
+				benchScrapes(b, &options{Header: nats.Header{}}, reg, f.accept)
+			})
+		}
+	}
+}
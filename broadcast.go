@@ -0,0 +1,56 @@
+package promnats
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// WithBroadcastJitter delays replies to requests on broadcast subjects, the root
+// and every prefix of the ID, with a random duration up to max.
+// Requests on the exact ID are always answered at once.
+func WithBroadcastJitter(max time.Duration) Option {
+	return func(o *options) error {
+		if max < 0 {
+			return errors.New("jitter must not be negative")
+		}
+		o.Jitter = max
+		return nil
+	}
+}
+
+// WithBroadcastLimit answers at most n requests on broadcast subjects per window.
+// Requests over the limit are dropped without reply.
+func WithBroadcastLimit(n int, window time.Duration) Option {
+	return func(o *options) error {
+		if n < 1 || window <= 0 {
+			return errors.New("limit and window must be positive")
+		}
+		o.limiter = &windowLimiter{max: n, window: window}
+		return nil
+	}
+}
+
+// windowLimiter allows max events per fixed window
+type windowLimiter struct {
+	mu     sync.Mutex
+	max    int
+	window time.Duration
+	start  time.Time
+	count  int
+}
+
+func (l *windowLimiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.start) >= l.window {
+		l.start = now
+		l.count = 0
+	}
+	if l.count >= l.max {
+		return false
+	}
+	l.count++
+	return true
+}
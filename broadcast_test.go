@@ -0,0 +1,28 @@
+package promnats
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_windowLimiter(t *testing.T) {
+	l := &windowLimiter{max: 2, window: 50 * time.Millisecond}
+	for i, want := range []bool{true, true, false} {
+		if got := l.allow(); got != want {
+			t.Errorf("allow() #%d = %v, want %v", i, got, want)
+		}
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !l.allow() {
+		t.Errorf("allow() after window = false, want true")
+	}
+}
+
+func TestWithBroadcastLimit(t *testing.T) {
+	if err := WithBroadcastLimit(0, time.Second)(&options{}); err == nil {
+		t.Errorf("WithBroadcastLimit(0) want error")
+	}
+	if err := WithBroadcastJitter(-time.Second)(&options{}); err == nil {
+		t.Errorf("WithBroadcastJitter(-1s) want error")
+	}
+}
@@ -0,0 +1,126 @@
+package promnats
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// HeaderVersion is the protocol version of the sender.
+	// It is set on every reply and on requests from the gateway.
+	HeaderVersion = "Promnats-Version"
+	// HeaderCapabilities is a comma separated list of the capabilities of the sender.
+	HeaderCapabilities = "Promnats-Capabilities"
+
+	// ProtocolVersion is the version of the protocol spoken by this library.
+	// Senders without HeaderVersion are version 0. Version 2 sends
+	// HeaderAppVersion and HeaderAppRevision with every reply.
+	ProtocolVersion = 2
+)
+
+// Capabilities announced with HeaderCapabilities
+const (
+	CapChunking = "chunking"
+	CapJSON     = "json"
+	CapDelta    = "delta"
+	CapTiers    = "tiers"
+	CapSelector = "selector"
+	CapDiscover = "discover"
+	CapInfo     = "info"
+	CapPprof    = "pprof"
+	CapHTTP     = "http"
+)
+
+// Capabilities is a set of capabilities
+type Capabilities map[string]bool
+
+// ParseCapabilities returns the capabilities announced in h.
+// It returns nil if h has no HeaderCapabilities, like senders
+// from before the capabilities were announced.
+func ParseCapabilities(h nats.Header) Capabilities {
+	v := h.Get(HeaderCapabilities)
+	if v == "" {
+		return nil
+	}
+	out := Capabilities{}
+	for _, c := range strings.Split(v, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			out[c] = true
+		}
+	}
+	return out
+}
+
+// Has returns true if c is in the set
+func (caps Capabilities) Has(c string) bool {
+	return caps[c]
+}
+
+// Allows returns true if c is in the set or the set is nil. Use it for features
+// that worked before capabilities were announced.
+func (caps Capabilities) Allows(c string) bool {
+	return caps == nil || caps[c]
+}
+
+// String returns the sorted, comma separated, capabilities
+func (caps Capabilities) String() string {
+	out := make([]string, 0, len(caps))
+	for c, ok := range caps {
+		if ok {
+			out = append(out, c)
+		}
+	}
+	sort.Strings(out)
+	return strings.Join(out, ",")
+}
+
+// Intersect returns the capabilities in both sets
+func (caps Capabilities) Intersect(other Capabilities) Capabilities {
+	out := Capabilities{}
+	for c, ok := range caps {
+		if ok && other[c] {
+			out[c] = true
+		}
+	}
+	return out
+}
+
+// PeerVersion returns the protocol version in h, 0 if it is missing or invalid
+func PeerVersion(h nats.Header) int {
+	v, err := strconv.Atoi(h.Get(HeaderVersion))
+	if err != nil || v < 0 {
+		return 0
+	}
+	return v
+}
+
+// capabilities returns what an instance with cfg supports
+func capabilities(cfg *options) Capabilities {
+	caps := Capabilities{
+		CapSelector: true,
+		CapDiscover: true,
+		CapInfo:     true,
+	}
+	if cfg.HTTPHandler != nil {
+		// metrics are served by the handler as is
+		caps[CapHTTP] = true
+	} else {
+		caps[CapJSON] = true
+		caps[CapDelta] = cfg.delta != nil
+		caps[CapTiers] = len(cfg.Tiers) > 0
+	}
+	if cfg.Pprof {
+		caps[CapPprof] = true
+		caps[CapChunking] = true
+	}
+	return caps
+}
+
+// setCapabilityHeaders sets the version and capabilities of cfg in h
+func setCapabilityHeaders(h nats.Header, cfg *options) {
+	h.Set(HeaderVersion, strconv.Itoa(ProtocolVersion))
+	h.Set(HeaderCapabilities, capabilities(cfg).String())
+}
@@ -0,0 +1,79 @@
+package promnats
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+		json   bool
+		allows bool
+	}{
+		{"legacy", "", "", false, true},
+		{"some", "json, delta,,chunking", "chunking,delta,json", true, true},
+		{"without json", "chunking", "chunking", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := nats.Header{}
+			if tt.header != "" {
+				h.Set(HeaderCapabilities, tt.header)
+			}
+			caps := ParseCapabilities(h)
+			if got := caps.String(); got != tt.want {
+				t.Errorf("ParseCapabilities() = %v, want %v", got, tt.want)
+			}
+			if got := caps.Has(CapJSON); got != tt.json {
+				t.Errorf("Has() = %v, want %v", got, tt.json)
+			}
+			if got := caps.Allows(CapJSON); got != tt.allows {
+				t.Errorf("Allows() = %v, want %v", got, tt.allows)
+			}
+		})
+	}
+}
+
+func Test_capabilities(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"default", nil, "discover,info,json,selector"},
+		{"all", []Option{WithDelta(0), WithPprof(0), WithOnDemandCollector("deep", prometheus.NewGauge(prometheus.GaugeOpts{Name: "deep"}))}, "chunking,delta,discover,info,json,pprof,selector,tiers"},
+		{"http", []Option{WithHTTPHandler(http.NotFoundHandler()), WithDelta(0)}, "discover,http,info,selector"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &options{}
+			for _, o := range tt.opts {
+				o(cfg)
+			}
+			if got := capabilities(cfg).String(); got != tt.want {
+				t.Errorf("capabilities() = %v, want %v", got, tt.want)
+			}
+			other := Capabilities{CapJSON: true, CapHTTP: true, "compression": true}
+			if got := capabilities(cfg).Intersect(other); got.Has("compression") || got.Has(CapJSON) != capabilities(cfg).Has(CapJSON) {
+				t.Errorf("Intersect() = %v", got)
+			}
+		})
+	}
+}
+
+func TestPeerVersion(t *testing.T) {
+	h := nats.Header{}
+	if v := PeerVersion(h); v != 0 {
+		t.Errorf("PeerVersion() = %d, want 0", v)
+	}
+	h.Set(HeaderVersion, "1")
+	if v := PeerVersion(h); v != 1 {
+		t.Errorf("PeerVersion() = %d, want 1", v)
+	}
+}
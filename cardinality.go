@@ -0,0 +1,148 @@
+package promnats
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// LimitedFamilyName is the family that reports the series removed by the cardinality limits
+const LimitedFamilyName = "promnats_cardinality_limited"
+
+// LimitAction is what happens to a family over the cardinality limit
+type LimitAction int
+
+const (
+	// LimitTruncate keeps the first series of the family, by labels, up to the limit
+	LimitTruncate LimitAction = iota
+	// LimitDrop removes the whole family
+	LimitDrop
+)
+
+// CardinalityError is passed to the callback of WithCardinalityCallback
+// for every family that was limited.
+type CardinalityError struct {
+	Family string
+	// Series is the number of series the family had
+	Series int
+	// Removed is the number of series removed
+	Removed int
+	// Total is true if the total limit was reached, not the one per family
+	Total bool
+}
+
+func (e *CardinalityError) Error() string {
+	limit := "family"
+	if e.Total {
+		limit = "total"
+	}
+	return fmt.Sprintf("%s has %d series, %d removed by the %s cardinality limit", e.Family, e.Series, e.Removed, limit)
+}
+
+// cardinalityLimits are the limits set with WithCardinalityLimit
+type cardinalityLimits struct {
+	perFamily int
+	total     int
+	action    LimitAction
+	callback  func(error)
+}
+
+// WithCardinalityLimit limits the number of series per family and in total in
+// every reply, 0 means no limit. Families over a limit are truncated or dropped
+// depending on action and reported in a promnats_cardinality_limited{family} gauge
+// with the number of series removed.
+func WithCardinalityLimit(perFamily, total int, action LimitAction) Option {
+	return func(o *options) error {
+		if perFamily < 0 || total < 0 {
+			return errors.New("limits must not be negative")
+		}
+		if action != LimitTruncate && action != LimitDrop {
+			return fmt.Errorf("unknown limit action %d", action)
+		}
+		if o.limits == nil {
+			o.limits = &cardinalityLimits{}
+		}
+		o.limits.perFamily = perFamily
+		o.limits.total = total
+		o.limits.action = action
+		return nil
+	}
+}
+
+// WithCardinalityCallback calls fn with a *CardinalityError for every family
+// limited by WithCardinalityLimit. fn is called while replying and should return quickly.
+func WithCardinalityCallback(fn func(error)) Option {
+	return func(o *options) error {
+		if o.limits == nil {
+			o.limits = &cardinalityLimits{}
+		}
+		o.limits.callback = fn
+		return nil
+	}
+}
+
+// apply returns mfs within the limits. Limited families are reported
+// in an added LimitedFamilyName family.
+func (l *cardinalityLimits) apply(mfs []*dto.MetricFamily) []*dto.MetricFamily {
+	if l.perFamily == 0 && l.total == 0 {
+		return mfs
+	}
+	out := make([]*dto.MetricFamily, 0, len(mfs)+1)
+	limited := []*CardinalityError{}
+	total := 0
+	for _, mf := range mfs {
+		n := len(mf.Metric)
+		keep := n
+		byTotal := false
+		if l.perFamily > 0 && keep > l.perFamily {
+			keep = l.perFamily
+		}
+		if l.total > 0 && total+keep > l.total {
+			keep = l.total - total
+			byTotal = true
+		}
+		if keep < n && l.action == LimitDrop {
+			keep = 0
+		}
+		if keep < n {
+			limited = append(limited, &CardinalityError{Family: mf.GetName(), Series: n, Removed: n - keep, Total: byTotal})
+		}
+		total += keep
+		switch {
+		case keep == n:
+			out = append(out, mf)
+		case keep > 0:
+			out = append(out, &dto.MetricFamily{
+				Name:   mf.Name,
+				Help:   mf.Help,
+				Type:   mf.Type,
+				Unit:   mf.Unit,
+				Metric: mf.Metric[:keep],
+			})
+		}
+	}
+	if len(limited) == 0 {
+		return out
+	}
+
+	report := &dto.MetricFamily{
+		Name: proto.String(LimitedFamilyName),
+		Help: proto.String("Number of series removed from a family by the cardinality limits."),
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+	for _, e := range limited {
+		report.Metric = append(report.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{{Name: proto.String("family"), Value: proto.String(e.Family)}},
+			Gauge: &dto.Gauge{Value: proto.Float64(float64(e.Removed))},
+		})
+		if l.callback != nil {
+			l.callback(e)
+		}
+	}
+	out = append(out, report)
+	sort.Slice(out, func(i, j int) bool { return out[i].GetName() < out[j].GetName() })
+	return out
+}
@@ -0,0 +1,69 @@
+package promnats
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// series returns a gauge family with n series
+func series(name string, n int) *dto.MetricFamily {
+	mf := gauge(name, 0)
+	mf.Metric = nil
+	for i := 0; i < n; i++ {
+		mf.Metric = append(mf.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{{Name: proto.String("i"), Value: proto.String(fmt.Sprint(i))}},
+			Gauge: &dto.Gauge{Value: proto.Float64(1)},
+		})
+	}
+	return mf
+}
+
+func Test_cardinalityLimits_apply(t *testing.T) {
+	tests := []struct {
+		name      string
+		perFamily int
+		total     int
+		action    LimitAction
+		want      map[string]int
+		limited   map[string]float64
+	}{
+		{"no limits", 0, 0, LimitTruncate, map[string]int{"a": 3, "b": 10, "c": 1}, nil},
+		{"truncate family", 5, 0, LimitTruncate, map[string]int{"a": 3, "b": 5, "c": 1}, map[string]float64{"b": 5}},
+		{"drop family", 5, 0, LimitDrop, map[string]int{"a": 3, "c": 1}, map[string]float64{"b": 10}},
+		{"truncate total", 0, 8, LimitTruncate, map[string]int{"a": 3, "b": 5}, map[string]float64{"b": 5, "c": 1}},
+		{"drop total", 0, 8, LimitDrop, map[string]int{"a": 3, "c": 1}, map[string]float64{"b": 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reported []string
+			l := &cardinalityLimits{perFamily: tt.perFamily, total: tt.total, action: tt.action, callback: func(err error) {
+				reported = append(reported, err.(*CardinalityError).Family)
+			}}
+			mfs := l.apply([]*dto.MetricFamily{series("a", 3), series("b", 10), series("c", 1)})
+			got := map[string]int{}
+			limited := map[string]float64{}
+			for _, mf := range mfs {
+				if mf.GetName() == LimitedFamilyName {
+					for _, m := range mf.Metric {
+						limited[m.Label[0].GetValue()] = m.Gauge.GetValue()
+					}
+					continue
+				}
+				got[mf.GetName()] = len(mf.Metric)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("apply() = %v, want %v", got, tt.want)
+			}
+			if len(limited) != len(tt.limited) || (len(limited) > 0 && !reflect.DeepEqual(limited, tt.limited)) {
+				t.Errorf("limited = %v, want %v", limited, tt.limited)
+			}
+			if len(reported) != len(tt.limited) {
+				t.Errorf("callback called for %v", reported)
+			}
+		})
+	}
+}
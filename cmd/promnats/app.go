@@ -8,8 +8,10 @@ import (
 	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/kmpm/promnats.go"
 	"github.com/nats-io/nats.go"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -19,17 +21,32 @@ type application struct {
 	server  *http.Server
 
 	discoveries map[string]discovered
+	selected    map[string]*selection
 	mu          sync.Mutex
 	closing     bool
 	wg          sync.WaitGroup
 	nc          *nats.Conn
 	meterSelf   bool
+	pprof       bool
+	port        int
+	announceSub *nats.Subscription
+	discovering atomic.Bool
+	delta       bool
+	deltas      map[string]*deltaState
+	deltaMu     sync.Mutex
+	pushes      *pushStore
+	pushSub     *nats.Subscription
+	scrapes     *scrapeMetrics
 }
 
 func newApp() *application {
 	return &application{
 		servers:     make(map[int]*http.Server),
 		discoveries: map[string]discovered{},
+		selected:    map[string]*selection{},
+		deltas:      map[string]*deltaState{},
+		pushes:      newPushStore(0),
+		scrapes:     newScrapeMetrics(),
 		meterSelf:   true,
 	}
 }
@@ -41,6 +58,14 @@ func (a *application) stop() error {
 	defer cancel()
 
 	a.server.Shutdown(ctx)
+	if a.announceSub != nil {
+		a.announceSub.Unsubscribe()
+		metSubGauge.Dec()
+	}
+	if a.pushSub != nil {
+		a.pushSub.Unsubscribe()
+		metSubGauge.Dec()
+	}
 
 	for _, s := range a.servers {
 		err := s.Shutdown(ctx)
@@ -55,6 +80,35 @@ func (a *application) stop() error {
 
 }
 
+// rediscover discovers paths for every known selector and refreshes them.
+// Does nothing if a rediscovery is already running.
+func (a *application) rediscover() {
+	if !a.discovering.CompareAndSwap(false, true) {
+		return
+	}
+	defer a.discovering.Store(false)
+	a.mu.Lock()
+	a.expireSelectors(time.Now())
+	selectors := []string{""}
+	for s := range a.selected {
+		if s != "" {
+			selectors = append(selectors, s)
+		}
+	}
+	a.mu.Unlock()
+	for _, selector := range selectors {
+		paths, err := discoverPaths(context.Background(), a.nc, a.port, selector)
+		if err != nil {
+			slog.Error("error discovering paths", "error", err, "selector", selector)
+			continue
+		}
+		err = a.updatePaths(selector, paths)
+		if err != nil {
+			slog.Error("error refreshing paths", "error", err, "selector", selector)
+		}
+	}
+}
+
 // shiftPath splits the given path into the first segment (head) and
 // the rest (tail). For example, "/foo/bar/baz" gives "foo", "/bar/baz".
 func shiftPath(p string) (head, tail string) {
@@ -70,10 +124,14 @@ func (a *application) start(addr, host string, startport int) error {
 	if a.server != nil {
 		return fmt.Errorf("can not start a started application")
 	}
+	a.port = startport
 	mux := http.NewServeMux()
 	// mux.HandleFunc("/discover", handleDiscovery(a.nc, startport, host, a.refresh))
-	handleDiscovery := handleDiscoveryPaths(a.nc, startport, host, a.meterSelf, a.refreshPaths)
+	handleDiscovery := handleDiscoveryPaths(a.nc, startport, host, a.meterSelf, a.pushes, a.refreshPaths)
 	handlePath := a.makePathHandler()
+	handlePprof := a.makePprofHandler()
+	handleInfo := a.makeInfoHandler()
+	handlePushed := a.makePushedHandler()
 	if a.meterSelf {
 		mux.Handle("/promnats", promhttp.Handler())
 	}
@@ -86,28 +144,53 @@ func (a *application) start(addr, host string, startport int) error {
 		switch head {
 		case "metrics":
 			if len(a.discoveries) == 0 {
-				go func() {
-					paths, err := discoverPaths(context.Background(), a.nc, startport)
-					if err != nil {
-						slog.Error("error discovering paths", "error", err)
-						return
-					}
-					err = a.refreshPaths(paths)
-					if err != nil {
-						slog.Error("error refreshing paths", "error", err)
-						return
-					}
-				}()
+				go a.rediscover()
 			}
 			handlePath(w, r)
 			return
 		case "discover":
 			handleDiscovery(w, r)
 			return
+		case "info":
+			handleInfo(w, r)
+			return
+		case "pushed":
+			handlePushed(w, r)
+			return
+		case "debug":
+			if a.pprof {
+				handlePprof(w, r)
+				return
+			}
 		}
 		http.NotFound(w, r)
 	})
 
+	// instances announce when they change id
+	sub, err := a.nc.Subscribe(promnats.AnnounceSubject("metrics"), func(m *nats.Msg) {
+		slog.Info("id changed", "from", m.Header.Get(promnats.HeaderPreviousID), "to", m.Header.Get(promnats.HeaderPnID))
+		infos.forget(m.Header.Get(promnats.HeaderPreviousID), m.Header.Get(promnats.HeaderPnID))
+		go a.rediscover()
+	})
+	if err != nil {
+		return err
+	}
+	a.announceSub = sub
+	metSubGauge.Inc()
+
+	// batch jobs push their metrics before they exit
+	sub, err = a.nc.Subscribe(promnats.PushSubject("metrics", ">"), a.handlePush)
+	if err != nil {
+		return err
+	}
+	a.pushSub = sub
+	metSubGauge.Inc()
+	if opts.PushBucket != "" {
+		if err := a.watchPushBucket(context.Background(), opts.PushBucket); err != nil {
+			return fmt.Errorf("error watching push bucket: %w", err)
+		}
+	}
+
 	a.server = &http.Server{
 		Addr:    addr,
 		Handler: WrapHandler(mux),
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kmpm/promnats.go"
+	"github.com/nats-io/nats.go"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+var errDeltaMismatch = errors.New("delta reply does not match the known generation")
+
+// deltaState is the merged metric families of a target using delta scrapes
+type deltaState struct {
+	mu         sync.Mutex
+	generation string
+	families   map[string]*dto.MetricFamily
+}
+
+// deltaFor returns the state for id, creating it if needed
+func (a *application) deltaFor(id string) *deltaState {
+	a.deltaMu.Lock()
+	defer a.deltaMu.Unlock()
+	st, ok := a.deltas[id]
+	if !ok {
+		st = &deltaState{generation: "0", families: map[string]*dto.MetricFamily{}}
+		a.deltas[id] = st
+	}
+	return st
+}
+
+// pruneDeltas removes state for targets no longer discovered
+func (a *application) pruneDeltas(discoveries map[string]discovered) {
+	ids := make(map[string]bool, len(discoveries))
+	for _, d := range discoveries {
+		ids[d.id] = true
+	}
+	a.deltaMu.Lock()
+	defer a.deltaMu.Unlock()
+	for id := range a.deltas {
+		if !ids[id] {
+			delete(a.deltas, id)
+		}
+	}
+}
+
+// merge applies a reply to the state and returns all families sorted by name.
+// Replies without a generation are returned as they are.
+// st.mu must be held.
+func (st *deltaState) merge(msg *nats.Msg) ([]*dto.MetricFamily, error) {
+	mfs, err := decodeFamilies(msg)
+	if err != nil {
+		return nil, err
+	}
+	gen := msg.Header.Get(promnats.HeaderGeneration)
+	if gen == "" {
+		// responder does not support delta
+		return mfs, nil
+	}
+	if base := msg.Header.Get(promnats.HeaderDeltaBase); base == "" {
+		st.families = map[string]*dto.MetricFamily{}
+	} else if base != st.generation {
+		st.generation = "0"
+		st.families = map[string]*dto.MetricFamily{}
+		return nil, errDeltaMismatch
+	}
+	for _, mf := range mfs {
+		st.families[mf.GetName()] = mf
+	}
+	if removed := msg.Header.Get(promnats.HeaderRemoved); removed != "" {
+		for _, name := range strings.Split(removed, ",") {
+			delete(st.families, name)
+		}
+	}
+	st.generation = gen
+
+	out := make([]*dto.MetricFamily, 0, len(st.families))
+	for _, mf := range st.families {
+		out = append(out, mf)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GetName() < out[j].GetName() })
+	return out, nil
+}
+
+// decodeFamilies decodes the metric families in msg using its content type
+func decodeFamilies(msg *nats.Msg) ([]*dto.MetricFamily, error) {
+	format := expfmt.ResponseFormat(http.Header{"Content-Type": {msg.Header.Get("Content-Type")}})
+	dec := expfmt.NewDecoder(bytes.NewReader(msg.Data), format)
+	mfs := []*dto.MetricFamily{}
+	for {
+		mf := &dto.MetricFamily{}
+		err := dec.Decode(mf)
+		if errors.Is(err, io.EOF) {
+			return mfs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		mfs = append(mfs, mf)
+	}
+}
+
+// encodeFamilies replaces the data of msg with mfs in format, or json if asJSON
+func encodeFamilies(msg *nats.Msg, mfs []*dto.MetricFamily, format expfmt.Format, asJSON bool) error {
+	var buf bytes.Buffer
+	if asJSON {
+		if err := promnats.EncodeJSON(&buf, mfs); err != nil {
+			return err
+		}
+		msg.Header.Set("Content-Type", promnats.ContentTypeJSON)
+		msg.Data = buf.Bytes()
+		return nil
+	}
+	enc := expfmt.NewEncoder(&buf, format)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	msg.Header.Set("Content-Type", string(format))
+	msg.Data = buf.Bytes()
+	return nil
+}
@@ -3,13 +3,17 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/kmpm/promnats.go"
 	"github.com/nats-io/nats.go"
+	"github.com/prometheus/common/model"
 )
 
 // https://prometheus.io/docs/prometheus/latest/http_sd/
@@ -25,11 +29,22 @@ type discovered struct {
 	id    string
 	parts []string
 	port  int
+	// appVersion and appRevision are the version and revision of the instance
+	appVersion  string
+	appRevision string
+	tiers       []string
+	labels      map[string]string
+	// caps is nil for responders that don't announce capabilities
+	caps    promnats.Capabilities
+	version int
+
+	interval time.Duration
+	timeout  time.Duration
 }
 
 // handleDiscoryPaths create a http handler that returns a JSON for prometheus http service discovery
 // that uses custome metrics_path instead of /metrics on different ports
-func handleDiscoveryPaths(nc *nats.Conn, startport int, host string, meterSelf bool, refresh func(map[string]discovered) error) func(http.ResponseWriter, *http.Request) {
+func handleDiscoveryPaths(nc *nats.Conn, startport int, host string, meterSelf bool, pushes *pushStore, refresh func(string, map[string]discovered) error) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// ask for data using a nats request
 		slog.Debug("disovering metrics for paths")
@@ -37,8 +52,15 @@ func handleDiscoveryPaths(nc *nats.Conn, startport int, host string, meterSelf b
 		defer func() {
 			slog.Debug("discovery paths done", "error", err)
 		}()
+		selector := r.URL.Query().Get("selector")
+		if selector != "" {
+			if _, err = promnats.ParseSelector(selector); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 		var discoveries map[string]discovered
-		discoveries, err = discoverPaths(r.Context(), nc, startport)
+		discoveries, err = discoverPaths(r.Context(), nc, startport, selector)
 		if err != nil {
 			slog.Warn("error discovering paths", "error", err)
 		}
@@ -62,16 +84,42 @@ func handleDiscoveryPaths(nc *nats.Conn, startport int, host string, meterSelf b
 					"__meta_prometheus_job": grpn,
 					"subject_group":         grpn,
 					"app":                   grpn,
-					"task":                  dg.parts[2],
-					"cluster":               dg.parts[1],
-					"app_cluster":           strings.Join(dg.parts[:2], "."),
-					"app_cluster_task":      strings.Join(dg.parts[:3], "."),
 					"__metrics_path__":      "metrics/" + path,
 				},
 			}
+			// ids set with WithID can have fewer parts than the default app.host.pid
+			if len(dg.parts) > 1 {
+				entry.Labels["cluster"] = dg.parts[1]
+				entry.Labels["app_cluster"] = strings.Join(dg.parts[:2], ".")
+			}
+			if len(dg.parts) > 2 {
+				entry.Labels["task"] = dg.parts[2]
+				entry.Labels["app_cluster_task"] = strings.Join(dg.parts[:3], ".")
+			}
+			if interval, timeout := scrapeHints(dg); interval > 0 {
+				entry.Labels["__scrape_interval__"] = model.Duration(interval).String()
+				entry.Labels["__scrape_timeout__"] = model.Duration(timeout).String()
+			}
+			for name, value := range dg.labels {
+				entry.Labels["__meta_promnats_label_"+name] = value
+			}
+			if include := includeFor(dg.tiers); include != "" {
+				// prometheus sends __param_* labels as query parameters
+				entry.Labels["__param_include"] = include
+			}
+			if dg.appVersion != "" {
+				entry.Labels["version"] = dg.appVersion
+			}
+			if dg.appRevision != "" {
+				entry.Labels["revision"] = dg.appRevision
+			}
 
 			httpsd = append(httpsd, entry)
 		}
+		if selector == "" {
+			// pushed snapshots have no labels to select on
+			httpsd = append(httpsd, pushedEntries(pushes.list(), host, startport)...)
+		}
 		if r.URL.Query().Get("pretty") != "" {
 			enc := json.NewEncoder(w)
 			enc.SetIndent("", "  ")
@@ -91,7 +139,7 @@ func handleDiscoveryPaths(nc *nats.Conn, startport int, host string, meterSelf b
 			return
 		}
 
-		err = refresh(discoveries)
+		err = refresh(selector, discoveries)
 		if err != nil {
 			slog.Error("error refreshing", "error", err)
 		}
@@ -99,24 +147,180 @@ func handleDiscoveryPaths(nc *nats.Conn, startport int, host string, meterSelf b
 	}
 }
 
-func discoverPaths(ctx context.Context, nc *nats.Conn, port int) (discoveries map[string]discovered, err error) {
+// legacyCheck is how often everyone is asked for metrics when no instance
+// of an older version answered the last time, like after a rollback
+const legacyCheck = 5 * time.Minute
+
+var (
+	// legacySeen is true while instances without promnats.CapDiscover answer,
+	// like during a rolling upgrade
+	legacySeen atomic.Bool
+	// legacyChecked is the unix nano time everyone was last asked for metrics,
+	// zero so they are found at startup
+	legacyChecked atomic.Int64
+)
+
+// discoverPaths finds the instances matching selector, or all if selector is empty.
+// Instances not knowing the discovery subject are asked for metrics as long as any answer.
+func discoverPaths(ctx context.Context, nc *nats.Conn, port int, selector string) (discoveries map[string]discovered, err error) {
 	var msgs []*nats.Msg
 	discoveries = make(map[string]discovered)
-	msgs, err = doReq(ctx, nil, "metrics", 0, nc)
-	if err != nil {
-		return
+	hdr := nats.Header{}
+	if selector != "" {
+		hdr.Set(promnats.HeaderSelector, selector)
+	}
+	legacy := opts.LegacyDiscovery || legacySeen.Load() ||
+		time.Since(time.Unix(0, legacyChecked.Load())) >= legacyCheck
+	if !opts.LegacyDiscovery {
+		msgs, err = doReq(ctx, nil, promnats.DiscoverSubject("metrics"), hdr, 0, nc)
+		if errors.Is(err, nats.ErrNoResponders) {
+			legacy = true
+		} else if err != nil {
+			return
+		}
 	}
-
 	for _, m := range msgs {
-		pnid := m.Header.Get(promnats.HeaderPnID)
-		if pnid == "" {
-			continue
+		if path, d, ok := parseDiscovered(m, port); ok {
+			discoveries[path] = d
+			slog.Info("something discovered", "pnid", d.id, "path", path)
 		}
-		parts := strings.Split(pnid, ".")
-		d := discovered{id: pnid, parts: parts, port: port}
-		path := strings.ToLower(strings.Join(parts, "/"))
-		discoveries[path] = d
-		slog.Info("something discovered", "pnid", pnid, "path", path)
 	}
+	if legacy {
+		// ask everyone for metrics, older versions of the library will ignore the selector
+		slog.Debug("using legacy discovery")
+		legacyChecked.Store(time.Now().UnixNano())
+		msgs, err = doReq(ctx, nil, "metrics", hdr, 0, nc)
+		if err != nil && !errors.Is(err, nats.ErrNoResponders) {
+			return
+		}
+		err = nil
+		seen := false
+		for _, m := range msgs {
+			path, d, ok := parseDiscovered(m, port)
+			if !ok {
+				continue
+			}
+			if !d.caps.Has(promnats.CapDiscover) {
+				seen = true
+			}
+			// instances answering both were found on the discovery subject already
+			if _, ok := discoveries[path]; !ok {
+				discoveries[path] = d
+				slog.Info("something discovered", "pnid", d.id, "path", path, "legacy", true)
+			}
+		}
+		if seen && !opts.LegacyDiscovery && !legacySeen.Load() {
+			// without -legacy-discovery they would be missing while others know $discover
+			slog.Warn("instances of older versions found, asking everyone for metrics while they answer")
+		}
+		legacySeen.Store(seen)
+	}
+	infos.fill(ctx, nc, discoveries)
 	return discoveries, nil
 }
+
+// parseDiscovered returns the http path and discovery of a reply from an instance
+func parseDiscovered(m *nats.Msg, port int) (string, discovered, bool) {
+	pnid := m.Header.Get(promnats.HeaderPnID)
+	if pnid == "" {
+		return "", discovered{}, false
+	}
+	parts := strings.Split(pnid, ".")
+	d := discovered{id: pnid, parts: parts, port: port}
+	if tiers := m.Header.Get(promnats.HeaderTiers); tiers != "" {
+		d.tiers = strings.Split(tiers, ",")
+	}
+	d.labels = advertisedLabels(m.Header)
+	d.caps = promnats.ParseCapabilities(m.Header)
+	d.version = promnats.PeerVersion(m.Header)
+	d.interval, _ = time.ParseDuration(m.Header.Get(promnats.HeaderScrapeInterval))
+	d.timeout, _ = time.ParseDuration(m.Header.Get(promnats.HeaderScrapeTimeout))
+	d.appVersion = m.Header.Get(promnats.HeaderAppVersion)
+	d.appRevision = m.Header.Get(promnats.HeaderAppRevision)
+	return strings.ToLower(strings.Join(parts, "/")), d, true
+}
+
+// includeFor returns the tiers from opts.Include that the target has
+func includeFor(tiers []string) string {
+	out := []string{}
+	for _, want := range strings.Split(opts.Include, ",") {
+		for _, t := range tiers {
+			if t == strings.TrimSpace(want) {
+				out = append(out, t)
+			}
+		}
+	}
+	return strings.Join(out, ",")
+}
+
+// advertisedLabels returns the allowed labels from the promnats.HeaderLabelPrefix
+// headers with sanitized names
+func advertisedLabels(h nats.Header) map[string]string {
+	out := map[string]string{}
+	for k, v := range h {
+		name, ok := strings.CutPrefix(k, promnats.HeaderLabelPrefix)
+		if !ok || len(v) == 0 {
+			continue
+		}
+		name = sanitizeLabelName(name)
+		if strings.HasPrefix(name, "__") || !labelAllowed(name) {
+			// names starting with __ are reserved
+			continue
+		}
+		out[name] = v[0]
+	}
+	return out
+}
+
+// sanitizeLabelName replaces everything not allowed in a prometheus label name with _
+func sanitizeLabelName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// labelAllowed checks name against opts.Labels. All labels are allowed if it is empty
+func labelAllowed(name string) bool {
+	if opts.Labels == "" {
+		return true
+	}
+	for _, allowed := range strings.Split(opts.Labels, ",") {
+		if strings.TrimSpace(allowed) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// scrapeHints returns the interval and timeout advertised by d clamped to the
+// bounds in opts. The timeout is never longer than the interval.
+// Returns 0, 0 if d has no interval.
+func scrapeHints(d discovered) (interval, timeout time.Duration) {
+	if d.interval <= 0 {
+		return 0, 0
+	}
+	interval = clamp(d.interval, opts.MinInterval, opts.MaxInterval)
+	timeout = d.timeout
+	if timeout <= 0 {
+		timeout = interval
+	}
+	timeout = clamp(timeout, opts.MinTimeout, opts.MaxTimeout)
+	if timeout > interval {
+		timeout = interval
+	}
+	return interval, timeout
+}
+
+func clamp(d, min, max time.Duration) time.Duration {
+	if d < min {
+		return min
+	}
+	if max > 0 && d > max {
+		return max
+	}
+	return d
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kmpm/promnats.go"
+	"github.com/nats-io/nats.go"
+)
+
+// requestInfo asks the instance with id for its promnats.Info
+func requestInfo(ctx context.Context, nc *nats.Conn, id string) (*promnats.Info, error) {
+	msgs, err := doReq(ctx, nil, promnats.InfoSubject("metrics", id), nil, 1, nc)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) < 1 {
+		return nil, fmt.Errorf("no info from %s", id)
+	}
+	if status := msgs[0].Header.Get("Status"); status != "" {
+		return nil, fmt.Errorf("info from %s: %s %s", id, status, msgs[0].Header.Get("Description"))
+	}
+	info := &promnats.Info{}
+	err = json.Unmarshal(msgs[0].Data, info)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// infoTTL is how long the info of an instance is kept
+const infoTTL = 10 * time.Minute
+
+// infos is the info of instances that don't send their version with every reply
+var infos = newInfoCache(infoTTL)
+
+type cachedInfo struct {
+	info *promnats.Info
+	at   time.Time
+}
+
+// infoCache keeps the info of instances per ID, also when they didn't answer
+type infoCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]cachedInfo
+}
+
+func newInfoCache(ttl time.Duration) *infoCache {
+	return &infoCache{ttl: ttl, entries: map[string]cachedInfo{}}
+}
+
+// get returns the cached info of id and whether there is one
+func (c *infoCache) get(id string, now time.Time) (*promnats.Info, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if !ok || now.Sub(e.at) >= c.ttl {
+		return nil, false
+	}
+	return e.info, true
+}
+
+func (c *infoCache) set(id string, info *promnats.Info, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[id] = cachedInfo{info: info, at: now}
+}
+
+// forget removes the info of ids, like when an instance announces a new ID
+func (c *infoCache) forget(ids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range ids {
+		delete(c.entries, id)
+	}
+}
+
+// prune removes the info of instances not in discoveries and expired entries.
+// discoveries must be those of all selectors, see mergePaths.
+func (c *infoCache) prune(discoveries map[string]discovered, now time.Time) {
+	ids := make(map[string]bool, len(discoveries))
+	for _, d := range discoveries {
+		ids[d.id] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, e := range c.entries {
+		if !ids[id] || now.Sub(e.at) >= c.ttl {
+			delete(c.entries, id)
+		}
+	}
+}
+
+// fill sets the version and revision of discoveries from older versions of the
+// library, that don't send them with every reply. Their info is requested in
+// parallel if it isn't cached. Instances not answering are left without.
+func (c *infoCache) fill(ctx context.Context, nc *nats.Conn, discoveries map[string]discovered) {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	now := time.Now()
+	for path, d := range discoveries {
+		if d.version >= 2 || !d.caps.Allows(promnats.CapInfo) {
+			continue
+		}
+		if info, ok := c.get(d.id, now); ok {
+			discoveries[path] = withInfo(d, info)
+			continue
+		}
+		wg.Add(1)
+		go func(path string, d discovered) {
+			defer wg.Done()
+			info, err := requestInfo(ctx, nc, d.id)
+			if err != nil {
+				slog.Debug("no info", "id", d.id, "error", err)
+			}
+			// not answering is cached too, so it doesn't cost a timeout every time
+			c.set(d.id, info, now)
+			mu.Lock()
+			discoveries[path] = withInfo(d, info)
+			mu.Unlock()
+		}(path, d)
+	}
+	wg.Wait()
+}
+
+// withInfo returns d with the version and revision of info, if any
+func withInfo(d discovered, info *promnats.Info) discovered {
+	if info != nil {
+		d.appVersion = info.Version
+		d.appRevision = info.Revision
+	}
+	return d
+}
+
+// makeInfoHandler returns a http handler that responds with the info of /<path>
+func (a *application) makeInfoHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		key := strings.Trim(r.URL.Path, "/")
+		disc, ok := a.lookup(key)
+		if !ok {
+			slog.Warn("not found", "path", r.URL.Path, "key", key)
+			http.Error(w, "not found", http.StatusNotFound)
+			metPathFails.Inc()
+			return
+		}
+		msgs, err := doReq(r.Context(), nil, promnats.InfoSubject("metrics", disc.id), nil, waitforLimit, a.nc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			slog.Error("info request error", "error", err, "id", disc.id)
+			metPathFails.Inc()
+			return
+		}
+		if len(msgs) < 1 {
+			http.Error(w, fmt.Sprintf("%s not found", disc.id), http.StatusNotFound)
+			metPathFails.Inc()
+			return
+		}
+		if status := msgs[0].Header.Get("Status"); status != "" {
+			code, err := strconv.Atoi(status)
+			if err != nil || code < 400 {
+				code = http.StatusInternalServerError
+			}
+			http.Error(w, msgs[0].Header.Get("Description"), code)
+			slog.Warn("info request failed", "status", status, "id", disc.id)
+			metPathFails.Inc()
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		size, err := w.Write(msgs[0].Data)
+		if err != nil {
+			slog.Warn("error responding", "error", err, "id", disc.id, "response_time", time.Since(start))
+		} else {
+			slog.Debug("responding", "id", disc.id, "size", size, "response_time", time.Since(start))
+		}
+	}
+}
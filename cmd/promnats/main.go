@@ -25,9 +25,25 @@ type options struct {
 	Context string
 	Server  string
 	Nkey    string
+	SignKey string
 	Timeout time.Duration
+	Idle    time.Duration
 	Address string
 	Host    string
+	Pprof   bool
+	Delta   bool
+	Include string
+	Labels  string
+
+	PushRetention time.Duration
+	PushBucket    string
+
+	MinInterval time.Duration
+	MaxInterval time.Duration
+	MinTimeout  time.Duration
+	MaxTimeout  time.Duration
+
+	LegacyDiscovery bool
 }
 
 var opts *options
@@ -47,12 +63,25 @@ func main() {
 	flag.StringVar(&opts.Context, "context", "", "<context name> to use for connection")
 	flag.StringVar(&opts.Server, "server", nats.DefaultURL, "server like "+nats.DefaultURL)
 	flag.StringVar(&opts.Nkey, "nkey", "", "path to nkey file")
+	flag.StringVar(&opts.SignKey, "sign-nkey", "", "path to user nkey seed file used to sign requests. can be the same as -nkey")
 
 	// flags for other config
 	flag.DurationVar(&opts.Timeout, "timeout", time.Second*2, "time waiting for replies")
+	flag.DurationVar(&opts.Idle, "idle", time.Millisecond*300, "time waiting for more replies to broadcasts. should be longer than any jitter used by instances")
 
 	flag.StringVar(&opts.Address, "address", ":8083", "address to listen on")
 	flag.StringVar(&opts.Host, "host", "", "host to use for http_sd. defaults to local IP if only 1")
+	flag.BoolVar(&opts.LegacyDiscovery, "legacy-discovery", false, "always discover by requesting metrics from all instances. older versions of the library are otherwise found while they answer")
+	flag.BoolVar(&opts.Delta, "delta", false, "ask instances for changed metric families only and keep the merged state")
+	flag.StringVar(&opts.Include, "include", "", "comma separated on demand tiers to include when scraping discovered targets that have them")
+	flag.StringVar(&opts.Labels, "labels", "", "comma separated labels advertised by instances to add as __meta_promnats_label_<name>. all if empty")
+	flag.DurationVar(&opts.MinInterval, "min-interval", time.Second, "shortest scrape interval advertised to prometheus")
+	flag.DurationVar(&opts.MaxInterval, "max-interval", time.Minute*10, "longest scrape interval advertised to prometheus")
+	flag.DurationVar(&opts.MinTimeout, "min-timeout", time.Second, "shortest scrape timeout advertised to prometheus")
+	flag.DurationVar(&opts.MaxTimeout, "max-timeout", time.Minute, "longest scrape timeout advertised to prometheus")
+	flag.DurationVar(&opts.PushRetention, "push-retention", time.Hour*24, "how long pushed metrics are kept. 0 keeps them until replaced")
+	flag.StringVar(&opts.PushBucket, "push-bucket", "", "JetStream KV bucket to read pushed metrics from")
+	flag.BoolVar(&opts.Pprof, "pprof", false, "proxy /debug/pprof/<path>/<profile> to instances using WithPprof")
 	// flags not in opts
 	var showVersion bool
 	flag.BoolVar(&showVersion, "version", false, "show version and eit")
@@ -115,7 +144,17 @@ func main() {
 		}
 		opts.Host = ips[0]
 	}
+	if opts.SignKey != "" {
+		signer, err = loadSigner(opts.SignKey)
+		if err != nil {
+			slog.Error("error loading nkey for signing", "error", err)
+			os.Exit(1)
+		}
+	}
 	app := newApp()
+	app.pprof = opts.Pprof
+	app.delta = opts.Delta
+	app.pushes = newPushStore(opts.PushRetention)
 
 	appname := "promnats " + appVersion
 
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"sync"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -26,7 +29,7 @@ var (
 		Help: "Total number discovered hosts",
 	})
 
-	metPathRequests = prometheus.NewCounterVec(
+	metPathRequests = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "promnats_paths_total",
 			Help: "How many path requests processed, partitioned by subject",
@@ -38,4 +41,121 @@ var (
 		Name: "promnats_path_fails",
 		Help: "Total number of path requests failed",
 	})
+
+	metPathFailReasons = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "promnats_path_failures_total",
+			Help: "Total number of path requests failed, partitioned by reason",
+		},
+		[]string{"reason"},
+	)
+
+	metPushes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "promnats_pushes_total",
+			Help: "Total number of pushed snapshots received, partitioned by result",
+		},
+		[]string{"result"},
+	)
+
+	metPushedJobs = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "promnats_pushed_jobs",
+		Help: "Number of pushed snapshots kept",
+	})
+
+	metScrapeDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "promnats_scrape_duration_seconds",
+			Help:    "Duration of successful scrapes, partitioned by target",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"target"},
+	)
+
+	metScrapeReplyBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "promnats_scrape_reply_bytes",
+			Help: "Size of the nats reply of the last successful scrape, partitioned by target",
+		},
+		[]string{"target"},
+	)
+
+	metScrapeRTT = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "promnats_scrape_rtt_seconds",
+			Help: "Time from the nats request to the reply of the last successful scrape, partitioned by target",
+		},
+		[]string{"target"},
+	)
+
+	metScrapeFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "promnats_scrape_failures_total",
+			Help: "Total number of failed scrapes, partitioned by target and reason",
+		},
+		[]string{"target", "reason"},
+	)
+
+	metScrapeLastSuccess = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "promnats_scrape_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful scrape, partitioned by target",
+		},
+		[]string{"target"},
+	)
 )
+
+// scrapeMetrics keeps the per target metrics of scrapes and
+// removes them when the target is no longer discovered
+type scrapeMetrics struct {
+	mu      sync.Mutex
+	targets map[string]bool
+}
+
+func newScrapeMetrics() *scrapeMetrics {
+	return &scrapeMetrics{targets: map[string]bool{}}
+}
+
+func (s *scrapeMetrics) seen(target string) {
+	s.mu.Lock()
+	s.targets[target] = true
+	s.mu.Unlock()
+}
+
+// success records a scrape of target that took d, of which rtt waiting for the nats reply of size bytes
+func (s *scrapeMetrics) success(target string, d, rtt time.Duration, size int) {
+	s.seen(target)
+	metScrapeDuration.WithLabelValues(target).Observe(d.Seconds())
+	metScrapeReplyBytes.WithLabelValues(target).Set(float64(size))
+	metScrapeRTT.WithLabelValues(target).Set(rtt.Seconds())
+	metScrapeLastSuccess.WithLabelValues(target).SetToCurrentTime()
+}
+
+// fail records a failed scrape of target
+func (s *scrapeMetrics) fail(target, reason string) {
+	s.seen(target)
+	metScrapeFailures.WithLabelValues(target, reason).Inc()
+}
+
+// prune removes the metrics of targets not in discoveries
+func (s *scrapeMetrics) prune(discoveries map[string]discovered) {
+	ids := make(map[string]bool, len(discoveries))
+	for _, d := range discoveries {
+		ids[d.id] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for target := range s.targets {
+		if ids[target] {
+			continue
+		}
+		labels := prometheus.Labels{"target": target}
+		metScrapeDuration.DeletePartialMatch(labels)
+		metScrapeReplyBytes.DeletePartialMatch(labels)
+		metScrapeRTT.DeletePartialMatch(labels)
+		metScrapeFailures.DeletePartialMatch(labels)
+		metScrapeLastSuccess.DeletePartialMatch(labels)
+		metPathRequests.DeletePartialMatch(prometheus.Labels{"subject": target})
+		delete(s.targets, target)
+	}
+}
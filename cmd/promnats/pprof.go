@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kmpm/promnats.go"
+	"github.com/nats-io/nats.go"
+)
+
+// makePprofHandler returns a http handler that proxies
+// /pprof/<path>/<profile> to the pprof subject of the discovered instance.
+// It mimics net/http/pprof so that `go tool pprof` can be used against it.
+func (a *application) makePprofHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		head, tail := shiftPath(r.URL.Path)
+		if head != "pprof" {
+			http.NotFound(w, r)
+			return
+		}
+		dir, profile := path.Split(strings.Trim(tail, "/"))
+		key := strings.Trim(dir, "/")
+		disc, ok := a.lookup(key)
+		if !ok || profile == "" || !disc.caps.Allows(promnats.CapPprof) {
+			slog.Warn("not found", "path", r.URL.Path, "key", key, "profile", profile)
+			http.Error(w, "not found", http.StatusNotFound)
+			metPathFails.Inc()
+			return
+		}
+
+		q := r.URL.Query()
+		// same defaults as net/http/pprof
+		seconds := 0
+		switch profile {
+		case "profile":
+			seconds = 30
+		case "trace":
+			seconds = 1
+		}
+		if s := q.Get("seconds"); s != "" {
+			var err error
+			seconds, err = strconv.Atoi(s)
+			if err != nil || seconds < 0 {
+				http.Error(w, "invalid seconds", http.StatusBadRequest)
+				return
+			}
+		}
+
+		msg := nats.NewMsg(promnats.PprofSubject("metrics", disc.id, profile))
+		msg.Header.Set(promnats.HeaderSeconds, strconv.Itoa(seconds))
+		if v := q.Get("debug"); v != "" {
+			msg.Header.Set(promnats.HeaderDebug, v)
+		}
+		if v := q.Get("gc"); v != "" {
+			msg.Header.Set(promnats.HeaderGC, v)
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), opts.Timeout+time.Duration(seconds)*time.Second)
+		defer cancel()
+		resp, err := doChunkedReq(ctx, msg, a.nc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			slog.Error("pprof request error", "error", err, "subject", msg.Subject)
+			metPathFails.Inc()
+			return
+		}
+		if status := resp.Header.Get("Status"); status != "" {
+			code, err := strconv.Atoi(status)
+			if err != nil || code < 400 {
+				code = http.StatusInternalServerError
+			}
+			http.Error(w, resp.Header.Get("Description"), code)
+			slog.Warn("pprof request failed", "status", status, "subject", msg.Subject)
+			metPathFails.Inc()
+			return
+		}
+
+		ct := resp.Header.Get("Content-Type")
+		w.Header().Set("X-Promnats-ID", resp.Header.Get(promnats.HeaderPnID))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Type", ct)
+		if !strings.HasPrefix(ct, "text/") {
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, profile))
+		}
+		size, err := w.Write(resp.Data)
+		if err != nil {
+			slog.Warn("error responding", "error", err, "subject", msg.Subject, "response_time", time.Since(start))
+		} else {
+			slog.Debug("responding", "subject", msg.Subject, "size", size, "response_time", time.Since(start))
+		}
+	}
+}
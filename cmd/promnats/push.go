@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kmpm/promnats.go"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+)
+
+const pushTimeName = "push_time_seconds"
+
+// pushed is the last snapshot pushed for a job
+type pushed struct {
+	id       string
+	labels   map[string]string
+	families []*dto.MetricFamily
+	at       time.Time
+}
+
+// pushStore keeps the last snapshot of every job, by path, for retention
+type pushStore struct {
+	mu        sync.Mutex
+	retention time.Duration
+	jobs      map[string]*pushed
+}
+
+func newPushStore(retention time.Duration) *pushStore {
+	return &pushStore{retention: retention, jobs: map[string]*pushed{}}
+}
+
+// pushPath returns the http path of a pushed id
+func pushPath(id string) string {
+	return strings.ToLower(strings.ReplaceAll(id, ".", "/"))
+}
+
+func (s *pushStore) put(p *pushed) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[pushPath(p.id)] = p
+	s.prune()
+}
+
+func (s *pushStore) get(path string) (*pushed, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	p, ok := s.jobs[path]
+	return p, ok
+}
+
+// list returns the snapshots by path
+func (s *pushStore) list() map[string]*pushed {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	out := make(map[string]*pushed, len(s.jobs))
+	for path, p := range s.jobs {
+		out[path] = p
+	}
+	return out
+}
+
+// prune removes snapshots older than the retention. s.mu must be held.
+func (s *pushStore) prune() {
+	if s.retention > 0 {
+		for path, p := range s.jobs {
+			if time.Since(p.at) > s.retention {
+				delete(s.jobs, path)
+			}
+		}
+	}
+	metPushedJobs.Set(float64(len(s.jobs)))
+}
+
+// handlePush stores a snapshot sent by promnats.PushOnce and acknowledges it
+func (a *application) handlePush(m *nats.Msg) {
+	id := strings.TrimPrefix(m.Subject, promnats.PushSubject("metrics", ""))
+	reply := nats.NewMsg(m.Subject)
+	reply.Header.Set(promnats.HeaderPnID, id)
+	mfs, err := decodeFamilies(m)
+	if err != nil || id == "" {
+		slog.Warn("invalid push", "subject", m.Subject, "error", err)
+		metPushes.WithLabelValues("invalid").Inc()
+		reply.Header.Set("Status", "400")
+		reply.Header.Set("Description", fmt.Sprintf("invalid push: %v", err))
+		m.RespondMsg(reply)
+		return
+	}
+	a.pushes.put(&pushed{id: id, labels: advertisedLabels(m.Header), families: mfs, at: time.Now()})
+	metPushes.WithLabelValues("ok").Inc()
+	slog.Debug("pushed", "id", id, "families", len(mfs))
+	if m.Reply != "" {
+		m.RespondMsg(reply)
+	}
+}
+
+// watchPushBucket stores the snapshots put in a KV bucket by promnats.PushOnce
+// with promnats.WithPushBucket
+func (a *application) watchPushBucket(ctx context.Context, bucket string) error {
+	js, err := jetstream.New(a.nc)
+	if err != nil {
+		return err
+	}
+	kv, err := js.KeyValue(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	w, err := kv.WatchAll(ctx)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for e := range w.Updates() {
+			if e == nil || e.Operation() != jetstream.KeyValuePut {
+				// nil marks the end of the initial values
+				continue
+			}
+			msg := nats.NewMsg(e.Key())
+			msg.Header.Set("Content-Type", string(expfmt.NewFormat(expfmt.TypeProtoDelim)))
+			msg.Data = e.Value()
+			mfs, err := decodeFamilies(msg)
+			if err != nil {
+				slog.Warn("invalid push in bucket", "bucket", bucket, "key", e.Key(), "error", err)
+				metPushes.WithLabelValues("invalid").Inc()
+				continue
+			}
+			a.pushes.put(&pushed{id: e.Key(), families: mfs, at: e.Created()})
+			metPushes.WithLabelValues("ok").Inc()
+		}
+	}()
+	return nil
+}
+
+// makePushedHandler returns a http handler that responds with the last snapshot of /<path>
+func (a *application) makePushedHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.Trim(r.URL.Path, "/")
+		p, ok := a.pushes.get(key)
+		if !ok {
+			pathFail(w, "not_found", http.StatusNotFound, "not found")
+			return
+		}
+		mfs := withPushTime(p)
+		msg := nats.NewMsg(p.id)
+		if err := encodeFamilies(msg, mfs, expfmt.Negotiate(r.Header), r.URL.Query().Get("format") == "json"); err != nil {
+			pathFail(w, "convert_error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Add("X-Promnats-ID", p.id)
+		w.Header().Add("Content-Type", msg.Header.Get("Content-Type"))
+		if _, err := w.Write(msg.Data); err != nil {
+			slog.Warn("error responding", "error", err, "id", p.id)
+		}
+	}
+}
+
+// withPushTime returns the families of p and push_time_seconds, sorted by name
+func withPushTime(p *pushed) []*dto.MetricFamily {
+	out := make([]*dto.MetricFamily, 0, len(p.families)+1)
+	for _, mf := range p.families {
+		if mf.GetName() != pushTimeName {
+			out = append(out, mf)
+		}
+	}
+	out = append(out, &dto.MetricFamily{
+		Name: proto.String(pushTimeName),
+		Help: proto.String("Last Unix time when the job pushed its metrics."),
+		Type: dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{
+			Gauge: &dto.Gauge{Value: proto.Float64(float64(p.at.UnixNano()) / 1e9)},
+		}},
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].GetName() < out[j].GetName() })
+	return out
+}
+
+// pushedEntries returns the http_sd entries of the pushed snapshots
+func pushedEntries(pushes map[string]*pushed, host string, port int) []HTTPEntry {
+	out := []HTTPEntry{}
+	for path, p := range pushes {
+		job, _, _ := strings.Cut(p.id, ".")
+		entry := HTTPEntry{
+			Targets: []string{fmt.Sprintf("%s:%d", host, port)},
+			Labels: map[string]string{
+				"__meta_prometheus_job": job,
+				"app":                   job,
+				"push_id":               p.id,
+				"__metrics_path__":      "pushed/" + path,
+			},
+		}
+		for name, value := range p.labels {
+			entry.Labels["__meta_promnats_label_"+name] = value
+		}
+		out = append(out, entry)
+	}
+	return out
+}
@@ -1,19 +1,72 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"os"
+	"strconv"
 
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/kmpm/promnats.go"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
 )
 
-func doReqAsync(ctx context.Context, req any, subj string, waitFor int, nc *nats.Conn, cb func(*nats.Msg)) error {
+// gatewayCaps is what the gateway can make use of in replies
+var gatewayCaps = promnats.Capabilities{
+	promnats.CapChunking: true,
+	promnats.CapJSON:     true,
+	promnats.CapDelta:    true,
+	promnats.CapTiers:    true,
+	promnats.CapSelector: true,
+	promnats.CapDiscover: true,
+	promnats.CapInfo:     true,
+	promnats.CapPprof:    true,
+}
+
+// signer signs requests if set, see promnats.WithAllowedRequesters
+var signer nkeys.KeyPair
+
+// loadSigner reads the nkey seed used to sign requests from path
+func loadSigner(path string) (nkeys.KeyPair, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return nkeys.ParseDecoratedNKey(data)
+}
+
+// sign signs msg if a signer is configured. The subject must be set.
+func sign(msg *nats.Msg) error {
+	if signer == nil {
+		return nil
+	}
+	return promnats.SignRequest(msg, signer)
+}
+
+// announce sets the protocol version and capabilities of the gateway in h
+func announce(h nats.Header) {
+	h.Set(promnats.HeaderVersion, strconv.Itoa(promnats.ProtocolVersion))
+	h.Set(promnats.HeaderCapabilities, gatewayCaps.String())
+}
+
+// features returns the capabilities both the gateway and a responder with caps have.
+// Responders that don't announce capabilities are assumed to have them all,
+// replies from them are checked before use.
+func features(caps promnats.Capabilities) promnats.Capabilities {
+	if caps == nil {
+		return gatewayCaps
+	}
+	return gatewayCaps.Intersect(caps)
+}
+
+func doReqAsync(ctx context.Context, req any, subj string, hdr nats.Header, waitFor int, nc *nats.Conn, cb func(*nats.Msg)) error {
 	jreq := []byte("{}")
 	var err error
 
@@ -35,7 +88,7 @@ func doReqAsync(ctx context.Context, req any, subj string, waitFor int, nc *nats
 
 	var finisher *time.Timer
 	if waitFor == 0 {
-		finisher = time.NewTimer(300 * time.Millisecond)
+		finisher = time.NewTimer(opts.Idle)
 		go func() {
 			select {
 			case <-finisher.C:
@@ -54,10 +107,10 @@ func doReqAsync(ctx context.Context, req any, subj string, waitFor int, nc *nats
 		slog.Debug("inbound", "subject", subj, "headers", m.Header)
 
 		if finisher != nil {
-			finisher.Reset(300 * time.Millisecond)
+			finisher.Reset(opts.Idle)
 		}
 
-		if m.Header.Get("Status") == "503" {
+		if isNoResponders(m) {
 			errs <- nats.ErrNoResponders
 			return
 		}
@@ -85,7 +138,16 @@ func doReqAsync(ctx context.Context, req any, subj string, waitFor int, nc *nats
 	msg := nats.NewMsg(subj)
 	msg.Data = jreq
 	msg.Reply = sub.Subject
-	msg.Header.Add("Accept", "text/html")
+	for k, v := range hdr {
+		msg.Header[k] = v
+	}
+	if msg.Header.Get("Accept") == "" {
+		msg.Header.Add("Accept", "text/html")
+	}
+	announce(msg.Header)
+	if err = sign(msg); err != nil {
+		return err
+	}
 
 	err = nc.PublishMsg(msg)
 	if err != nil {
@@ -108,16 +170,100 @@ func doReqAsync(ctx context.Context, req any, subj string, waitFor int, nc *nats
 	return nil
 }
 
-// doReq sends request to subject and return any replies
-// stops at opts.Timeout or waitFor number of replies if > 0
-func doReq(ctx context.Context, req any, subj string, waitFor int, nc *nats.Conn) ([]*nats.Msg, error) {
+// doReq sends request with optional headers to subject and return any replies
+// stops at opts.Timeout or waitFor number of replies if > 0,
+// or when no reply has arrived for opts.Idle if waitFor is 0
+func doReq(ctx context.Context, req any, subj string, hdr nats.Header, waitFor int, nc *nats.Conn) ([]*nats.Msg, error) {
 	res := []*nats.Msg{}
 	mu := sync.Mutex{}
 
-	err := doReqAsync(ctx, req, subj, waitFor, nc, func(m *nats.Msg) {
+	err := doReqAsync(ctx, req, subj, hdr, waitFor, nc, func(m *nats.Msg) {
 		mu.Lock()
 		res = append(res, m)
 		mu.Unlock()
 	})
 	return res, err
 }
+
+// isNoResponders returns true if m is the no responders status of the server.
+// Instances can reply 503 too, like the http bridge, but those have a promnats.HeaderPnID.
+func isNoResponders(m *nats.Msg) bool {
+	return m.Header.Get("Status") == "503" && m.Header.Get(promnats.HeaderPnID) == ""
+}
+
+const (
+	// maxChunkedSize is the largest chunked reply accepted, like a profile
+	maxChunkedSize = 512 << 20
+	// maxChunks is the most chunks of a reply, instances send chunks of at least 4KiB
+	maxChunks = maxChunkedSize / (4 << 10)
+)
+
+// doChunkedReq publishes msg and waits for a single, possibly chunked, reply.
+// Chunks are joined in order and returned as one message.
+// stops when all chunks are received or ctx is done
+func doChunkedReq(ctx context.Context, msg *nats.Msg, nc *nats.Conn) (*nats.Msg, error) {
+	sub, err := nc.SubscribeSync(nc.NewRespInbox())
+	if err != nil {
+		return nil, err
+	}
+	metSubGauge.Inc()
+	defer func() {
+		sub.Unsubscribe()
+		metSubGauge.Dec()
+	}()
+
+	msg.Reply = sub.Subject
+	announce(msg.Header)
+	if err = sign(msg); err != nil {
+		return nil, err
+	}
+	err = nc.PublishMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+	metPubCounter.Inc()
+
+	var (
+		first  *nats.Msg
+		chunks [][]byte
+		seen   []bool
+		got    int
+	)
+	for {
+		m, err := sub.NextMsgWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if isNoResponders(m) {
+			return nil, nats.ErrNoResponders
+		}
+		total, err := strconv.Atoi(m.Header.Get(promnats.HeaderChunks))
+		if err != nil {
+			// not chunked
+			return m, nil
+		}
+		if first == nil {
+			if total <= 0 || total > maxChunks {
+				return nil, fmt.Errorf("invalid number of chunks %d", total)
+			}
+			first = m
+			chunks = make([][]byte, total)
+			seen = make([]bool, total)
+		} else if total != len(chunks) {
+			return nil, fmt.Errorf("chunk with %d chunks in a reply of %d", total, len(chunks))
+		}
+		idx, err := strconv.Atoi(m.Header.Get(promnats.HeaderChunk))
+		if err != nil || idx < 0 || idx >= len(chunks) {
+			return nil, fmt.Errorf("invalid chunk '%s' of %d", m.Header.Get(promnats.HeaderChunk), total)
+		}
+		if !seen[idx] {
+			seen[idx] = true
+			got++
+		}
+		chunks[idx] = m.Data
+		if got == len(chunks) {
+			first.Data = bytes.Join(chunks, nil)
+			return first, nil
+		}
+	}
+}
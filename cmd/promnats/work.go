@@ -2,26 +2,113 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/kmpm/promnats.go"
+	"github.com/nats-io/nats.go"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
 )
 
 const waitforLimit = 1
 
-func (a *application) refreshPaths(discoveries map[string]discovered) error {
+const (
+	// selectorTTL is how long the discoveries of a selector are kept
+	// after it was last requested
+	selectorTTL = 10 * time.Minute
+	// maxSelectors is the most selectors kept, the least recently requested are dropped
+	maxSelectors = 100
+)
+
+// selection is the discoveries made with a selector
+type selection struct {
+	discoveries map[string]discovered
+	requested   time.Time
+}
+
+// refreshPaths replaces the discoveries made with a requested selector.
+// Paths are looked up in the discoveries of all selectors.
+func (a *application) refreshPaths(selector string, discoveries map[string]discovered) error {
 	a.mu.Lock()
 	defer func() {
 		slog.Debug("refreshPaths done")
 		a.mu.Unlock()
 	}()
-	slog.Debug("refreshPaths")
-	a.discoveries = discoveries
+	slog.Debug("refreshPaths", "selector", selector)
+	now := time.Now()
+	a.selected[selector] = &selection{discoveries: discoveries, requested: now}
+	a.expireSelectors(now)
+	a.mergePaths()
+	return nil
+}
+
+// updatePaths replaces the discoveries of selector without counting it as requested.
+// Selectors expired meanwhile are not added again, except the empty one.
+func (a *application) updatePaths(selector string, discoveries map[string]discovered) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sel, ok := a.selected[selector]
+	if !ok {
+		if selector != "" {
+			return nil
+		}
+		sel = &selection{}
+		a.selected[selector] = sel
+	}
+	sel.discoveries = discoveries
+	a.mergePaths()
 	return nil
 }
 
+// expireSelectors drops selectors not requested for selectorTTL and the least
+// recently requested ones above maxSelectors. The empty selector is always kept,
+// it is what rediscover uses anyway. a.mu must be held.
+func (a *application) expireSelectors(now time.Time) {
+	for s, sel := range a.selected {
+		if s != "" && now.Sub(sel.requested) >= selectorTTL {
+			slog.Debug("selector expired", "selector", s)
+			delete(a.selected, s)
+		}
+	}
+	for len(a.selected) > maxSelectors {
+		oldest := ""
+		for s, sel := range a.selected {
+			if s != "" && (oldest == "" || sel.requested.Before(a.selected[oldest].requested)) {
+				oldest = s
+			}
+		}
+		slog.Debug("too many selectors", "dropped", oldest)
+		delete(a.selected, oldest)
+	}
+}
+
+// mergePaths makes the discoveries of all selectors current. a.mu must be held.
+func (a *application) mergePaths() {
+	all := make(map[string]discovered)
+	for _, sel := range a.selected {
+		for path, d := range sel.discoveries {
+			all[path] = d
+		}
+	}
+	a.discoveries = all
+	a.pruneDeltas(all)
+	a.scrapes.prune(all)
+	infos.prune(all, time.Now())
+}
+
+// lookup returns the discovery for the http path key
+func (a *application) lookup(key string) (discovered, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	disc, ok := a.discoveries[key]
+	return disc, ok
+}
+
 func (a *application) makePathHandler() func(http.ResponseWriter, *http.Request) {
 	// return a http handler
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -30,37 +117,116 @@ func (a *application) makePathHandler() func(http.ResponseWriter, *http.Request)
 
 		key := strings.TrimPrefix(r.URL.Path, "/")
 
-		disc, ok := a.discoveries[key]
+		disc, ok := a.lookup(key)
 		if !ok {
-			slog.Warn("not found", "path", r.URL.Path, "key", key, "discoveries", a.discoveries)
-			http.Error(w, "not found", http.StatusNotFound)
-			metPathFails.Inc()
+			slog.Warn("not found", "path", r.URL.Path, "key", key)
+			// unknown paths are not targets, they are only counted by reason
+			pathFail(w, "not_found", http.StatusNotFound, "not found")
 			return
 		}
 		subj := disc.id
+		fail := func(reason string, code int, text string) {
+			pathFail(w, reason, code, text)
+			a.scrapes.fail(subj, reason)
+		}
 		// send nats request with context from http.Request
 		// wait for first answer
 		ctx, cancel := context.WithTimeout(r.Context(), opts.Timeout*5)
 		defer cancel()
-		msgs, err := doReq(ctx, nil, "metrics."+subj, waitforLimit, a.nc)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			slog.Error("doReq error", "error", err, "subject", subj)
-			metPathFails.Inc()
-			return
+		hdr := nats.Header{}
+		feat := features(disc.caps)
+		asJSON := r.URL.Query().Get("format") == "json"
+		if include := r.URL.Query().Get("include"); include != "" && feat.Has(promnats.CapTiers) {
+			hdr.Set(promnats.HeaderInclude, include)
+		}
+		var st *deltaState
+		if a.delta && feat.Has(promnats.CapDelta) {
+			// all families are decoded and merged, use the fastest format
+			st = a.deltaFor(subj)
+			st.mu.Lock()
+			defer st.mu.Unlock()
+			hdr.Set("Accept", string(expfmt.NewFormat(expfmt.TypeProtoDelim)))
+			hdr.Set(promnats.HeaderDelta, st.generation)
+		} else if asJSON && feat.Has(promnats.CapJSON) {
+			hdr.Set("Accept", promnats.ContentTypeJSON)
 		}
+		var rtt time.Duration
+		// request sends the request and fails on errors and error replies
+		request := func() (*nats.Msg, bool) {
+			sent := time.Now()
+			msgs, err := doReq(ctx, nil, "metrics."+subj, hdr, waitforLimit, a.nc)
+			rtt = time.Since(sent)
+			if errors.Is(err, nats.ErrNoResponders) {
+				slog.Warn("no responders", "subject", subj)
+				fail("no_responders", http.StatusServiceUnavailable, fmt.Sprintf("%s has no responders", subj))
+				return nil, false
+			}
+			if err != nil {
+				slog.Error("doReq error", "error", err, "subject", subj)
+				fail("request_error", http.StatusInternalServerError, err.Error())
+				return nil, false
+			}
 
-		// should have at least one message
-		if len(msgs) < 1 {
-			http.Error(w, fmt.Sprintf("%s not found", subj), http.StatusNotFound)
-			slog.Warn("not found", "subject", subj)
-			metPathFails.Inc()
+			// should have at least one message
+			if len(msgs) < 1 {
+				slog.Warn("timeout", "subject", subj)
+				fail("timeout", http.StatusGatewayTimeout, fmt.Sprintf("%s did not reply in time", subj))
+				return nil, false
+			}
+			metPathRequests.WithLabelValues(subj).Inc()
+			// get the first message
+			msg := msgs[0]
+			if status := msg.Header.Get("Status"); status != "" {
+				// the instance replied with an error
+				slog.Warn("error reply", "subject", subj, "status", status, "description", msg.Header.Get("Description"))
+				text := fmt.Sprintf("%s %s\n%s", status, msg.Header.Get("Description"), msg.Data)
+				switch status {
+				case "403":
+					fail("forbidden", http.StatusForbidden, text)
+				case "429":
+					fail("rate_limited", http.StatusTooManyRequests, text)
+				default:
+					fail("error_reply", http.StatusBadGateway, text)
+				}
+				return nil, false
+			}
+			return msg, true
+		}
+		msg, ok := request()
+		if !ok {
+			return
+		}
+		var err error
+		if st != nil {
+			var mfs []*dto.MetricFamily
+			mfs, err = st.merge(msg)
+			if errors.Is(err, errDeltaMismatch) {
+				// the base is dropped, ask for a full snapshot right away
+				slog.Debug("delta mismatch, requesting a full snapshot", "subject", subj)
+				hdr.Set(promnats.HeaderDelta, st.generation)
+				if msg, ok = request(); !ok {
+					return
+				}
+				mfs, err = st.merge(msg)
+			}
+			if err == nil {
+				err = encodeFamilies(msg, mfs, expfmt.Negotiate(r.Header), asJSON)
+			}
+		} else if asJSON && !strings.HasPrefix(msg.Header.Get("Content-Type"), promnats.ContentTypeJSON) {
+			// older versions of the library don't know about json
+			var mfs []*dto.MetricFamily
+			mfs, err = decodeFamilies(msg)
+			if err == nil {
+				err = encodeFamilies(msg, mfs, "", true)
+			}
+		}
+		if err != nil {
+			slog.Error("error converting metrics", "error", err, "subject", subj)
+			fail("convert_error", http.StatusInternalServerError, err.Error())
 			return
 		}
-		metPathRequests.WithLabelValues(subj).Inc()
-		// get the first message
-		msg := msgs[0]
 
+		replySize := len(msg.Data)
 		// add headers if we have them
 		w.Header().Add("X-Promnats-ID", msg.Header.Get("Promnats-ID"))
 		if ct := msg.Header.Get("Content-Type"); ct != "" {
@@ -70,8 +236,19 @@ func (a *application) makePathHandler() func(http.ResponseWriter, *http.Request)
 		size, err := w.Write(msg.Data)
 		if err != nil {
 			slog.Warn("error responding", "error", err, "subject", subj, "response_time", time.Since(start))
+			metPathFails.Inc()
+			metPathFailReasons.WithLabelValues("write_error").Inc()
+			a.scrapes.fail(subj, "write_error")
 		} else {
 			slog.Debug("responding", "subject", subj, "size", size, "response_time", time.Since(start), "error", err)
+			a.scrapes.success(subj, time.Since(start), rtt, replySize)
 		}
 	}
 }
+
+// pathFail responds with an error and counts the failure by reason
+func pathFail(w http.ResponseWriter, reason string, code int, text string) {
+	http.Error(w, text, code)
+	metPathFails.Inc()
+	metPathFailReasons.WithLabelValues(reason).Inc()
+}
@@ -0,0 +1,137 @@
+package promnats
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// HeaderProbe is set on requests that check if an ID is in use.
+// Instances answer them with headers only, and not at all if the value is their own.
+const HeaderProbe = "Promnats-Probe"
+
+// CollisionPolicy decides what to do when another instance answers on the ID
+type CollisionPolicy int
+
+const (
+	// CollisionWarn logs a warning and uses the ID anyway
+	CollisionWarn CollisionPolicy = iota
+	// CollisionFail returns ErrIDCollision
+	CollisionFail
+	// CollisionSuffix adds -2, -3 and so on to the last part of the ID until it is free
+	CollisionSuffix
+	// CollisionIgnore doesn't check
+	CollisionIgnore
+)
+
+// ErrIDCollision is returned when the ID is in use and the policy is CollisionFail
+var ErrIDCollision = errors.New("another instance answers on the id")
+
+const (
+	defaultProbeTimeout = 500 * time.Millisecond
+	maxSuffix           = 100
+)
+
+// WithCollisionPolicy sets what to do if another instance answers on the ID
+// when the handler is created or the ID is changed. The ID is probed with a request
+// on the exact ID subject, waiting at most timeout for a reply.
+// Defaults to CollisionWarn and 500ms.
+func WithCollisionPolicy(policy CollisionPolicy, timeout time.Duration) Option {
+	return func(o *options) error {
+		if policy < CollisionWarn || policy > CollisionIgnore {
+			return fmt.Errorf("unknown collision policy %d", policy)
+		}
+		if timeout <= 0 {
+			return errors.New("probe timeout must be positive")
+		}
+		o.Collision = policy
+		o.ProbeTimeout = timeout
+		return nil
+	}
+}
+
+// claimID probes the ID of cfg and applies the collision policy.
+// With CollisionSuffix the subjects and ID of cfg are changed.
+func (h *Handler) claimID(cfg *options) error {
+	if cfg.Collision == CollisionIgnore {
+		return nil
+	}
+	last := len(cfg.Subjects) - 1
+	base := cfg.Subjects[last]
+	for i := 2; ; i++ {
+		taken, err := h.probe(cfg)
+		if err != nil {
+			return err
+		}
+		if !taken {
+			return nil
+		}
+		switch cfg.Collision {
+		case CollisionWarn:
+			slog.Warn("another instance answers on the same id", "id", cfg.ID)
+			return nil
+		case CollisionFail:
+			return fmt.Errorf("%w: %s", ErrIDCollision, cfg.ID)
+		}
+		if i > maxSuffix {
+			return fmt.Errorf("%w: %s, no free suffix", ErrIDCollision, base)
+		}
+		// don't modify the subjects of another config
+		cfg.Subjects = append(cfg.Subjects[:last:last], fmt.Sprintf("%s-%d", base, i))
+		cfg.ID = genID(cfg.Subjects)
+	}
+}
+
+// probe returns true if another instance answers on the ID of cfg on any connection
+func (h *Handler) probe(cfg *options) (bool, error) {
+	for _, c := range h.conns {
+		taken, err := probeID(c.nc, cfg, h.nonce)
+		if taken || err != nil {
+			return taken, err
+		}
+	}
+	return false, nil
+}
+
+// probeID returns true if another instance answers on the exact ID of cfg.
+// Older versions answer on every prefix of their ID, so the replies are checked for the ID.
+func probeID(nc *nats.Conn, cfg *options, nonce string) (bool, error) {
+	sub, err := nc.SubscribeSync(nc.NewRespInbox())
+	if err != nil {
+		return false, err
+	}
+	defer sub.Unsubscribe()
+
+	msg := nats.NewMsg(cfg.subject(cfg.ID))
+	msg.Reply = sub.Subject
+	msg.Header.Set(HeaderProbe, nonce)
+	if err := nc.PublishMsg(msg); err != nil {
+		return false, err
+	}
+	deadline := time.Now().Add(cfg.ProbeTimeout)
+	for {
+		m, err := sub.NextMsg(time.Until(deadline))
+		if errors.Is(err, nats.ErrTimeout) || errors.Is(err, nats.ErrNoResponders) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if m.Header.Get(HeaderPnID) == cfg.ID {
+			return true, nil
+		}
+	}
+}
+
+// handleProbe answers a probe on the exact ID, unless it was sent by h
+func (h *Handler) handleProbe(msg *nats.Msg, cfg *options) {
+	if msg.Header.Get(HeaderProbe) == h.nonce || msg.Subject != cfg.subject(cfg.ID) {
+		return
+	}
+	if err := handleDiscover(msg, cfg); err != nil && cfg.Debug {
+		slog.Debug("error answering probe", "err", err)
+	}
+}
@@ -0,0 +1,145 @@
+package promnats
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWithCollisionPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  CollisionPolicy
+		timeout time.Duration
+		wantErr bool
+	}{
+		{"suffix", CollisionSuffix, time.Second, false},
+		{"ignore", CollisionIgnore, time.Second, false},
+		{"unknown", CollisionPolicy(42), time.Second, true},
+		{"no timeout", CollisionFail, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &options{}
+			err := WithCollisionPolicy(tt.policy, tt.timeout)(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WithCollisionPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (cfg.Collision != tt.policy || cfg.ProbeTimeout != tt.timeout) {
+				t.Errorf("WithCollisionPolicy() = %v, %v", cfg.Collision, cfg.ProbeTimeout)
+			}
+		})
+	}
+}
+
+func TestHandler_claimID(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  CollisionPolicy
+		want    []string
+		wantErr error
+	}{
+		{"warn", CollisionWarn, []string{"a.b", "a.b"}, nil},
+		{"ignore", CollisionIgnore, []string{"a.b", "a.b"}, nil},
+		{"fail", CollisionFail, []string{"a.b"}, ErrIDCollision},
+		{"suffix", CollisionSuffix, []string{"a.b", "a.b-2", "a.b-3"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := runServer(t)
+			var got []string
+			for range tt.want {
+				h, err := NewHandler(connect(t, s), WithID("a.b"), WithCollisionPolicy(tt.policy, 100*time.Millisecond))
+				if err != nil {
+					t.Fatalf("NewHandler() error = %v", err)
+				}
+				defer h.Close()
+				got = append(got, h.ID())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("IDs = %v, want %v", got, tt.want)
+			}
+			if tt.wantErr == nil {
+				return
+			}
+			_, err := NewHandler(connect(t, s), WithID("a.b"), WithCollisionPolicy(tt.policy, 100*time.Millisecond))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("NewHandler() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandler_probe(t *testing.T) {
+	s := runServer(t)
+	nc := connect(t, s)
+	h, err := NewHandler(nc, WithID("a.b"), WithCollisionPolicy(CollisionFail, 100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer h.Close()
+	// a handler on a prefix of the id answers broadcasts, but not probes for a.b
+	prefix, err := NewHandler(connect(t, s), WithID("a"), WithCollisionPolicy(CollisionFail, 100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer prefix.Close()
+	other, err := NewHandler(connect(t, s), WithID("c"), WithCollisionPolicy(CollisionFail, 100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer other.Close()
+
+	tests := []struct {
+		name string
+		h    *Handler
+		id   string
+		want bool
+	}{
+		{"own nonce", h, "a.b", false},
+		{"other instance", other, "a.b", true},
+		{"free id", other, "a.x", false},
+		{"prefix instance", other, "a", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &options{RootSubject: "metrics", ID: tt.id, ProbeTimeout: 100 * time.Millisecond}
+			got, err := tt.h.probe(cfg)
+			if err != nil {
+				t.Fatalf("probe() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("probe(%s) = %v, want %v", tt.id, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_SetID_suffix(t *testing.T) {
+	s := runServer(t)
+	first, err := NewHandler(connect(t, s), WithID("a.b"), WithCollisionPolicy(CollisionSuffix, 100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer first.Close()
+	h, err := NewHandler(connect(t, s), WithID("a.c"), WithCollisionPolicy(CollisionSuffix, 100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer h.Close()
+
+	if err := h.SetID("a.b"); err != nil {
+		t.Fatalf("SetID() error = %v", err)
+	}
+	if got := h.ID(); got != "a.b-2" {
+		t.Errorf("ID() = %q, want a.b-2", got)
+	}
+	// a.b is taken and h doesn't answer its own probe on a.b-2, so it keeps that
+	if err := h.SetID("a.b"); err != nil {
+		t.Fatalf("SetID() error = %v", err)
+	}
+	if got := h.ID(); got != "a.b-2" {
+		t.Errorf("ID() = %q, want a.b-2", got)
+	}
+}
@@ -0,0 +1,125 @@
+package promnats
+
+import (
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// connStatuses are the states reported by nats_client_status
+var connStatuses = []nats.Status{
+	nats.DISCONNECTED,
+	nats.CONNECTED,
+	nats.CLOSED,
+	nats.RECONNECTING,
+	nats.CONNECTING,
+	nats.DRAINING_SUBS,
+	nats.DRAINING_PUBS,
+}
+
+// connCollector collects the statistics of a nats connection
+type connCollector struct {
+	nc *nats.Conn
+
+	inMsgs     *prometheus.Desc
+	outMsgs    *prometheus.Desc
+	inBytes    *prometheus.Desc
+	outBytes   *prometheus.Desc
+	reconnects *prometheus.Desc
+	pending    *prometheus.Desc
+	rtt        *prometheus.Desc
+	status     *prometheus.Desc
+	server     *prometheus.Desc
+	maxPayload *prometheus.Desc
+}
+
+// NewConnCollector returns a collector for the statistics of nc.
+// labels are added to every metric, use them to tell connections apart.
+// The RTT is measured with a round trip to the server for every collect
+// and left out when not connected.
+func NewConnCollector(nc *nats.Conn, labels prometheus.Labels) prometheus.Collector {
+	desc := func(name, help string, variable ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("nats", "client", name), help, variable, labels)
+	}
+	return &connCollector{
+		nc:         nc,
+		inMsgs:     desc("in_msgs_total", "Number of messages received."),
+		outMsgs:    desc("out_msgs_total", "Number of messages sent."),
+		inBytes:    desc("in_bytes_total", "Number of bytes received."),
+		outBytes:   desc("out_bytes_total", "Number of bytes sent."),
+		reconnects: desc("reconnects_total", "Number of reconnects to the servers."),
+		pending:    desc("pending_bytes", "Number of bytes buffered to be sent."),
+		rtt:        desc("rtt_seconds", "Round trip time to the connected server."),
+		status:     desc("status", "Status of the connection, 1 for the current status.", "status"),
+		server:     desc("server_info", "The server the connection is connected to.", "server_id", "url"),
+		maxPayload: desc("max_payload_bytes", "Max payload allowed by the connected server."),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *connCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.inMsgs
+	ch <- c.outMsgs
+	ch <- c.inBytes
+	ch <- c.outBytes
+	ch <- c.reconnects
+	ch <- c.pending
+	ch <- c.rtt
+	ch <- c.status
+	ch <- c.server
+	ch <- c.maxPayload
+}
+
+// Collect implements prometheus.Collector
+func (c *connCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.nc.Stats()
+	ch <- prometheus.MustNewConstMetric(c.inMsgs, prometheus.CounterValue, float64(stats.InMsgs))
+	ch <- prometheus.MustNewConstMetric(c.outMsgs, prometheus.CounterValue, float64(stats.OutMsgs))
+	ch <- prometheus.MustNewConstMetric(c.inBytes, prometheus.CounterValue, float64(stats.InBytes))
+	ch <- prometheus.MustNewConstMetric(c.outBytes, prometheus.CounterValue, float64(stats.OutBytes))
+	ch <- prometheus.MustNewConstMetric(c.reconnects, prometheus.CounterValue, float64(stats.Reconnects))
+	if pending, err := c.nc.Buffered(); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.pending, prometheus.GaugeValue, float64(pending))
+	}
+
+	status := c.nc.Status()
+	for _, s := range connStatuses {
+		v := 0.0
+		if s == status {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.status, prometheus.GaugeValue, v, s.String())
+	}
+	if status != nats.CONNECTED {
+		return
+	}
+	if rtt, err := c.nc.RTT(); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.rtt, prometheus.GaugeValue, rtt.Seconds())
+	}
+	ch <- prometheus.MustNewConstMetric(c.server, prometheus.GaugeValue, 1, c.nc.ConnectedServerId(), c.nc.ConnectedUrlRedacted())
+	ch <- prometheus.MustNewConstMetric(c.maxPayload, prometheus.GaugeValue, float64(c.nc.MaxPayload()))
+}
+
+// WithConnCollector adds the statistics of the connection given to
+// RequestHandler or NewHandler to the gathered metrics, see NewConnCollector.
+// With more than one connection they are labeled by conn, replacing any conn in labels
+// as every connection needs its own value.
+func WithConnCollector(labels prometheus.Labels) Option {
+	return func(o *options) error {
+		o.connCollector = true
+		o.connLabels = labels
+		return nil
+	}
+}
+
+// connLabels returns labels with the conn label set to the name of c if there are several connections
+func connLabels(labels prometheus.Labels, c *handlerConn, n int) prometheus.Labels {
+	if n < 2 {
+		return labels
+	}
+	out := prometheus.Labels{}
+	for k, v := range labels {
+		out[k] = v
+	}
+	out["conn"] = c.name
+	return out
+}
@@ -0,0 +1,35 @@
+package promnats
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewConnCollector(t *testing.T) {
+	// a connection that never connected
+	c := NewConnCollector(&nats.Conn{}, prometheus.Labels{"conn": "main"})
+	want := `
+# HELP nats_client_status Status of the connection, 1 for the current status.
+# TYPE nats_client_status gauge
+nats_client_status{conn="main",status="CLOSED"} 0
+nats_client_status{conn="main",status="CONNECTED"} 0
+nats_client_status{conn="main",status="CONNECTING"} 0
+nats_client_status{conn="main",status="DISCONNECTED"} 1
+nats_client_status{conn="main",status="DRAINING_PUBS"} 0
+nats_client_status{conn="main",status="DRAINING_SUBS"} 0
+nats_client_status{conn="main",status="RECONNECTING"} 0
+# HELP nats_client_reconnects_total Number of reconnects to the servers.
+# TYPE nats_client_reconnects_total counter
+nats_client_reconnects_total{conn="main"} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nats_client_status", "nats_client_reconnects_total"); err != nil {
+		t.Error(err)
+	}
+	if err := testutil.CollectAndCompare(c, strings.NewReader(""), "nats_client_rtt_seconds", "nats_client_server_info"); err != nil {
+		t.Errorf("expected no rtt or server when disconnected: %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package promnats
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+)
+
+// handlerConn is a connection a Handler answers on
+type handlerConn struct {
+	nc *nats.Conn
+	// name is the conn label of the instrumentation
+	name string
+	subs map[string]*nats.Subscription
+}
+
+// connHandler handles a message received on c
+type connHandler func(c *handlerConn, msg *nats.Msg)
+
+// newConns names every connection by its client name, see nats.Name,
+// or by its index if it has none or the name is used already.
+func newConns(ncs []*nats.Conn) ([]*handlerConn, error) {
+	if len(ncs) == 0 {
+		return nil, errors.New("no connection given")
+	}
+	conns := make([]*handlerConn, 0, len(ncs))
+	names := map[string]bool{}
+	for i, nc := range ncs {
+		if nc == nil {
+			return nil, errors.New("connection must not be nil")
+		}
+		for _, c := range conns {
+			if c.nc == nc {
+				return nil, errors.New("connection given more than once")
+			}
+		}
+		name := nc.Opts.Name
+		if name == "" || names[name] {
+			name = strconv.Itoa(i)
+		}
+		names[name] = true
+		conns = append(conns, &handlerConn{nc: nc, name: name, subs: map[string]*nats.Subscription{}})
+	}
+	return conns, nil
+}
@@ -0,0 +1,111 @@
+package promnats
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func named(name string) *nats.Conn {
+	return &nats.Conn{Opts: nats.Options{Name: name}}
+}
+
+func Test_newConns(t *testing.T) {
+	same := named("a")
+	tests := []struct {
+		name    string
+		ncs     []*nats.Conn
+		want    []string
+		wantErr bool
+	}{
+		{"none", nil, nil, true},
+		{"nil", []*nats.Conn{nil}, nil, true},
+		{"unnamed", []*nats.Conn{named(""), named("")}, []string{"0", "1"}, false},
+		{"named", []*nats.Conn{named("primary"), named("dr")}, []string{"primary", "dr"}, false},
+		{"same name", []*nats.Conn{named("a"), named("a")}, []string{"a", "1"}, false},
+		{"same conn", []*nats.Conn{same, same}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conns, err := newConns(tt.ncs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newConns() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, c := range conns {
+				got = append(got, c.name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newConns() names = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_connLabels(t *testing.T) {
+	c := &handlerConn{name: "dr"}
+	tests := []struct {
+		name   string
+		labels prometheus.Labels
+		n      int
+		want   prometheus.Labels
+	}{
+		{"single", nil, 1, nil},
+		{"multi", nil, 2, prometheus.Labels{"conn": "dr"}},
+		{"multi with labels", prometheus.Labels{"app": "x"}, 2, prometheus.Labels{"app": "x", "conn": "dr"}},
+		{"conn given", prometheus.Labels{"conn": "mine"}, 1, prometheus.Labels{"conn": "mine"}},
+		{"multi conn given", prometheus.Labels{"conn": "mine"}, 2, prometheus.Labels{"conn": "dr"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := connLabels(tt.labels, c, tt.n); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("connLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithConnCollector_multi(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels prometheus.Labels
+	}{
+		{"no labels", nil},
+		{"conn given", prometheus.Labels{"conn": "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conns, err := newConns([]*nats.Conn{named("primary"), named("dr")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			g, err := gatherer(conns, &options{connCollector: true, connLabels: tt.labels})
+			if err != nil {
+				t.Fatalf("gatherer() error = %v", err)
+			}
+			mfs, done, err := g.Gather()
+			defer done()
+			if err != nil {
+				t.Fatalf("Gather() error = %v", err)
+			}
+			got := map[string]bool{}
+			for _, mf := range mfs {
+				if mf.GetName() != "nats_client_reconnects_total" {
+					continue
+				}
+				for _, m := range mf.Metric {
+					for _, l := range m.Label {
+						if l.GetName() == "conn" {
+							got[l.GetValue()] = true
+						}
+					}
+				}
+			}
+			if want := map[string]bool{"primary": true, "dr": true}; !reflect.DeepEqual(got, want) {
+				t.Errorf("conn labels = %v, want %v", got, want)
+			}
+		})
+	}
+}
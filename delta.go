@@ -0,0 +1,142 @@
+package promnats
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// HeaderDelta is sent by requesters that want a delta reply. The value is the
+	// generation of the last reply they have, or 0 if they have none.
+	HeaderDelta = "Promnats-Delta"
+	// HeaderGeneration is the generation of a delta reply
+	HeaderGeneration = "Promnats-Generation"
+	// HeaderDeltaBase is set when a reply only has the families changed since that generation.
+	// Without it the reply has all families.
+	HeaderDeltaBase = "Promnats-Delta-Base"
+	// HeaderRemoved is a comma separated list of families removed since HeaderDeltaBase
+	HeaderRemoved = "Promnats-Removed"
+
+	// DefaultDeltaHistory is the number of generations kept if nothing else is configured.
+	DefaultDeltaHistory = 16
+)
+
+// WithDelta lets requesters ask for only the metric families that changed
+// since a previous reply using HeaderDelta. The last history generations
+// are remembered, DefaultDeltaHistory is used if history <= 0.
+func WithDelta(history int) Option {
+	return func(o *options) error {
+		if history <= 0 {
+			history = DefaultDeltaHistory
+		}
+		o.delta = newDeltaTracker(history)
+		return nil
+	}
+}
+
+type generation struct {
+	token  string
+	hashes map[string]uint64
+}
+
+// deltaTracker remembers the family hashes of the latest generations
+type deltaTracker struct {
+	mu      sync.Mutex
+	session string
+	counter uint64
+	history []generation
+	size    int
+}
+
+func newDeltaTracker(size int) *deltaTracker {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return &deltaTracker{session: hex.EncodeToString(b), size: size}
+}
+
+// diff returns the families of mfs that changed since the generation given in req
+// and sets the delta headers in hdr.
+func (d *deltaTracker) diff(req nats.Header, mfs []*dto.MetricFamily, hdr nats.Header) []*dto.MetricFamily {
+	hashes := make(map[string]uint64, len(mfs))
+	for _, mf := range mfs {
+		hashes[mf.GetName()] = hashFamily(mf)
+	}
+
+	d.mu.Lock()
+	var base *generation
+	for i := range d.history {
+		if d.history[i].token == req.Get(HeaderDelta) {
+			base = &d.history[i]
+			break
+		}
+	}
+	cur := d.next(hashes)
+	d.mu.Unlock()
+
+	hdr.Set(HeaderGeneration, cur.token)
+	if base == nil {
+		return mfs
+	}
+
+	hdr.Set(HeaderDeltaBase, base.token)
+	changed := []*dto.MetricFamily{}
+	for _, mf := range mfs {
+		if h, ok := base.hashes[mf.GetName()]; !ok || h != hashes[mf.GetName()] {
+			changed = append(changed, mf)
+		}
+	}
+	removed := []string{}
+	for name := range base.hashes {
+		if _, ok := hashes[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	if len(removed) > 0 {
+		sort.Strings(removed)
+		hdr.Set(HeaderRemoved, strings.Join(removed, ","))
+	}
+	return changed
+}
+
+// next returns the generation for hashes, reusing the latest one if nothing changed.
+// d.mu must be held.
+func (d *deltaTracker) next(hashes map[string]uint64) generation {
+	if n := len(d.history); n > 0 && sameHashes(d.history[n-1].hashes, hashes) {
+		return d.history[n-1]
+	}
+	d.counter++
+	g := generation{token: d.session + "-" + strconv.FormatUint(d.counter, 10), hashes: hashes}
+	d.history = append(d.history, g)
+	if len(d.history) > d.size {
+		d.history = d.history[len(d.history)-d.size:]
+	}
+	return g
+}
+
+func sameHashes(a, b map[string]uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+func hashFamily(mf *dto.MetricFamily) uint64 {
+	b, _ := proto.MarshalOptions{Deterministic: true}.Marshal(mf)
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
@@ -0,0 +1,65 @@
+package promnats
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func gauge(name string, v float64) *dto.MetricFamily {
+	return &dto.MetricFamily{
+		Name:   proto.String(name),
+		Type:   dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(v)}}},
+	}
+}
+
+func names(mfs []*dto.MetricFamily) []string {
+	out := []string{}
+	for _, mf := range mfs {
+		out = append(out, mf.GetName())
+	}
+	return out
+}
+
+func Test_deltaTracker(t *testing.T) {
+	d := newDeltaTracker(2)
+
+	// first request has no base
+	hdr := nats.Header{}
+	got := d.diff(nats.Header{HeaderDelta: {"0"}}, []*dto.MetricFamily{gauge("a", 1), gauge("b", 1)}, hdr)
+	if len(got) != 2 || hdr.Get(HeaderDeltaBase) != "" {
+		t.Fatalf("diff() first = %v, base %q", names(got), hdr.Get(HeaderDeltaBase))
+	}
+	gen1 := hdr.Get(HeaderGeneration)
+
+	// nothing changed, same generation
+	hdr = nats.Header{}
+	got = d.diff(nats.Header{HeaderDelta: {gen1}}, []*dto.MetricFamily{gauge("a", 1), gauge("b", 1)}, hdr)
+	if len(got) != 0 || hdr.Get(HeaderGeneration) != gen1 || hdr.Get(HeaderDeltaBase) != gen1 {
+		t.Fatalf("diff() unchanged = %v, headers %v", names(got), hdr)
+	}
+
+	// a changed, b removed, c added
+	hdr = nats.Header{}
+	got = d.diff(nats.Header{HeaderDelta: {gen1}}, []*dto.MetricFamily{gauge("a", 2), gauge("c", 1)}, hdr)
+	if n := names(got); len(n) != 2 || n[0] != "a" || n[1] != "c" {
+		t.Errorf("diff() changed = %v, want [a c]", n)
+	}
+	if hdr.Get(HeaderRemoved) != "b" {
+		t.Errorf("diff() removed = %q, want b", hdr.Get(HeaderRemoved))
+	}
+	if hdr.Get(HeaderGeneration) == gen1 {
+		t.Errorf("diff() generation not changed")
+	}
+
+	// gen1 falls out of the history
+	d.diff(nats.Header{}, []*dto.MetricFamily{gauge("a", 3)}, nats.Header{})
+	hdr = nats.Header{}
+	got = d.diff(nats.Header{HeaderDelta: {gen1}}, []*dto.MetricFamily{gauge("a", 4)}, hdr)
+	if len(got) != 1 || hdr.Get(HeaderDeltaBase) != "" {
+		t.Errorf("diff() unknown base = %v, base %q", names(got), hdr.Get(HeaderDeltaBase))
+	}
+}
@@ -0,0 +1,402 @@
+package promnats
+
+import (
+	"log/slog"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// HeaderPreviousID is set in announcements to the ID used before the change.
+	HeaderPreviousID = "Promnats-Previous-ID"
+
+	announceToken = "$announce"
+)
+
+// Handler answers metrics requests over nats.
+// The ID can be changed at runtime using SetID or SetParts.
+type Handler struct {
+	conns []*handlerConn
+	reg   prometheus.TransactionalGatherer
+
+	// cfg is replaced, never modified, when the id changes
+	cfg atomic.Pointer[options]
+
+	// mu guards the subscriptions of conns
+	mu sync.Mutex
+
+	// nonce tells the probes of h from those of others
+	nonce string
+}
+
+// AnnounceSubject returns the subject where instances announce a changed ID.
+func AnnounceSubject(root string) string {
+	return root + "." + announceToken
+}
+
+// NewHandler subscribes to the configured subjects and answers requests
+// until Close is called.
+func NewHandler(nc *nats.Conn, opts ...Option) (*Handler, error) {
+	return NewHandlerMulti([]*nats.Conn{nc}, opts...)
+}
+
+// NewHandlerMulti is like NewHandler but answers on every connection in ncs,
+// like one for each cluster. The connections share the config, ID and gatherer.
+// The instrumentation of the library is labeled by conn, the client name of
+// the connection or its index if it has none.
+func NewHandlerMulti(ncs []*nats.Conn, opts ...Option) (*Handler, error) {
+	conns, err := newConns(ncs)
+	if err != nil {
+		return nil, err
+	}
+	//default
+	cfg := &options{
+		RootSubject:  "metrics",
+		Header:       nats.Header{},
+		ProbeTimeout: defaultProbeTimeout,
+	}
+
+	for _, o := range opts {
+		err := o(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.Subjects) == 0 {
+		cfg.Subjects = defaultSubjects()
+	}
+	cfg.ID = genID(cfg.Subjects)
+
+	reg, err := gatherer(conns, cfg)
+	if err != nil {
+		return nil, err
+	}
+	h := &Handler{
+		conns: conns,
+		reg:   reg,
+		nonce: nuid.Next(),
+	}
+	if err := h.claimID(cfg); err != nil {
+		return nil, err
+	}
+	setHeaders(cfg)
+	cfg.labels = selectorLabels(cfg)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.resubscribe(cfg); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// setHeaders sets the headers sent with every reply from the config
+func setHeaders(cfg *options) {
+	cfg.Header.Set(HeaderPnID, cfg.ID)
+	if len(cfg.Tiers) > 0 {
+		cfg.Header.Set(HeaderTiers, strings.Join(tierNames(cfg.Tiers), ","))
+	}
+	for k, v := range cfg.Metadata {
+		cfg.Header.Set(labelHeader(k), v)
+	}
+	setHintHeaders(cfg.Header, cfg)
+	setCapabilityHeaders(cfg.Header, cfg)
+	setInfoHeaders(cfg.Header, cfg)
+}
+
+// gatherer returns the default gatherer merged with the collectors and gatherers added by options,
+// cached for CacheTTL if set
+func gatherer(conns []*handlerConn, cfg *options) (prometheus.TransactionalGatherer, error) {
+	collectors := cfg.collectors
+	if cfg.connCollector {
+		for _, c := range conns {
+			collectors = append(collectors, NewConnCollector(c.nc, connLabels(cfg.connLabels, c, len(conns))))
+		}
+	}
+	if cfg.auth != nil {
+		collectors = append(collectors, metAuthRequests)
+	}
+	var g prometheus.Gatherer = prometheus.DefaultGatherer
+	if len(collectors) > 0 || len(cfg.gatherers) > 0 {
+		reg := prometheus.NewRegistry()
+		for _, c := range collectors {
+			if err := reg.Register(c); err != nil {
+				return nil, err
+			}
+		}
+		g = append(prometheus.Gatherers{prometheus.DefaultGatherer, reg}, cfg.gatherers...)
+	}
+	if cfg.CacheTTL > 0 {
+		return newCachedGatherer(prometheus.ToTransactionalGatherer(g), cfg.CacheTTL), nil
+	}
+	return prometheus.ToTransactionalGatherer(g), nil
+}
+
+// ID returns the current ID
+func (h *Handler) ID() string {
+	return h.cfg.Load().ID
+}
+
+// SetID will split id at . and replace the current subjects with one for each part
+func (h *Handler) SetID(id string) error {
+	return h.SetParts(strings.Split(id, ".")...)
+}
+
+// SetParts replaces the current subjects with one for each part.
+// New subscriptions are made before old ones are removed, if anything fails
+// the handler keeps the current ID. The change is announced on AnnounceSubject.
+func (h *Handler) SetParts(parts ...string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cur := h.cfg.Load()
+	next := *cur
+	next.Subjects = nil
+	if err := WithParts(parts...)(&next); err != nil {
+		return err
+	}
+	next.ID = genID(next.Subjects)
+	if next.ID == cur.ID {
+		return nil
+	}
+	if err := h.claimID(&next); err != nil {
+		return err
+	}
+	if next.ID == cur.ID {
+		// a suffix was added and it is the current one
+		return nil
+	}
+	next.Header = copyHeader(cur.Header)
+	next.Header.Set(HeaderPnID, next.ID)
+	next.labels = selectorLabels(&next)
+
+	if err := h.resubscribe(&next); err != nil {
+		return err
+	}
+
+	for _, c := range h.conns {
+		msg := nats.NewMsg(AnnounceSubject(next.RootSubject))
+		msg.Header = copyHeader(next.Header)
+		msg.Header.Set(HeaderPreviousID, cur.ID)
+		if err := c.nc.PublishMsg(msg); err != nil {
+			slog.Error("error announcing id", "err", err, "id", next.ID, "conn", c.name)
+		}
+	}
+	if next.Debug {
+		slog.Debug("changed id", "from", cur.ID, "to", next.ID)
+	}
+	return nil
+}
+
+// Close removes all subscriptions
+func (h *Handler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var err error
+	for _, c := range h.conns {
+		for subj, sub := range c.subs {
+			if uerr := sub.Unsubscribe(); uerr != nil && err == nil {
+				err = uerr
+			}
+			delete(c.subs, subj)
+		}
+	}
+	return err
+}
+
+// handlers returns the message handler for every subject cfg should be subscribed to
+func (h *Handler) handlers(cfg *options) map[string]connHandler {
+	out := make(map[string]connHandler)
+	for _, s := range cfg.Subjects {
+		out[cfg.subject(s)] = h.handleRequest
+	}
+	if cfg.HTTPHandler != nil {
+		out[HTTPSubject(cfg.RootSubject, cfg.ID, ">")] = h.handleHTTPSubject
+	}
+	out[DiscoverSubject(cfg.RootSubject)] = h.handleDiscover
+	out[InfoSubject(cfg.RootSubject, cfg.ID)] = h.handleInfo
+	if cfg.Pprof {
+		out[PprofSubject(cfg.RootSubject, cfg.ID, "*")] = h.handlePprof
+	}
+	return out
+}
+
+// resubscribe subscribes every connection to the subjects of cfg that are missing,
+// makes cfg current and then removes subscriptions no longer needed.
+// h.mu must be held.
+func (h *Handler) resubscribe(cfg *options) error {
+	if cfg.Debug {
+		slog.Debug("configured subjects", "subjects", cfg.Subjects)
+	}
+	// messages can arrive as soon as the first subscription is made
+	if h.cfg.Load() == nil {
+		h.cfg.Store(cfg)
+	}
+	want := h.handlers(cfg)
+	type added struct {
+		c    *handlerConn
+		subj string
+	}
+	done := []added{}
+	for _, c := range h.conns {
+		for subj, cb := range want {
+			if _, ok := c.subs[subj]; ok {
+				continue
+			}
+			sub, err := c.nc.Subscribe(subj, bind(c, cb))
+			if err != nil {
+				for _, a := range done {
+					a.c.subs[a.subj].Unsubscribe()
+					delete(a.c.subs, a.subj)
+				}
+				return err
+			}
+			c.subs[subj] = sub
+			done = append(done, added{c, subj})
+			if cfg.Debug {
+				slog.Debug("subscribing to", "subject", subj, "conn", c.name)
+			}
+		}
+	}
+
+	h.cfg.Store(cfg)
+
+	for _, c := range h.conns {
+		for subj, sub := range c.subs {
+			if _, ok := want[subj]; !ok {
+				sub.Unsubscribe()
+				delete(c.subs, subj)
+				if cfg.Debug {
+					slog.Debug("unsubscribing from", "subject", subj, "conn", c.name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// bind returns a message handler calling cb with c
+func bind(c *handlerConn, cb connHandler) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		cb(c, msg)
+	}
+}
+
+// handleRequest answers on the metrics subjects.
+// Requests on broadcast subjects are limited and delayed if configured.
+func (h *Handler) handleRequest(c *handlerConn, msg *nats.Msg) {
+	cfg := h.cfg.Load()
+	if probe := msg.Header.Get(HeaderProbe); probe != "" {
+		// probes ignore selectors, the ID is taken whatever the labels.
+		// Rejected probes get a status with the ID, so the ID is still taken.
+		if probe != h.nonce && authorize(msg, cfg, c.name) {
+			h.handleProbe(msg, cfg)
+		}
+		return
+	}
+	serve := h.handleMetrics
+	if cfg.HTTPHandler != nil {
+		serve = h.handleHTTP
+	}
+	if ok, err := selected(msg.Header, cfg); !ok {
+		if err != nil && cfg.Debug {
+			slog.Debug("invalid selector", "err", err)
+		}
+		return
+	}
+	if !authorize(msg, cfg, c.name) {
+		return
+	}
+	if msg.Subject == cfg.subject(cfg.ID) {
+		serve(msg)
+		return
+	}
+	if cfg.limiter != nil && !cfg.limiter.allow() {
+		if cfg.Debug {
+			slog.Debug("broadcast limit reached", "subject", msg.Subject)
+		}
+		return
+	}
+	if cfg.Jitter > 0 {
+		time.AfterFunc(time.Duration(rand.Int63n(int64(cfg.Jitter))), func() {
+			serve(msg)
+		})
+		return
+	}
+	serve(msg)
+}
+
+func (h *Handler) handleMetrics(msg *nats.Msg) {
+	cfg := h.cfg.Load()
+	err := handleMsg(msg, cfg, h.reg)
+	if err != nil {
+		//TODO: notify shomehow
+		if cfg.Debug {
+			slog.Debug("error handling message", "err", err)
+		}
+	}
+}
+
+func (h *Handler) handleHTTP(msg *nats.Msg) {
+	cfg := h.cfg.Load()
+	err := handleHTTP(msg, cfg)
+	if err != nil && cfg.Debug {
+		slog.Debug("error handling http", "err", err)
+	}
+}
+
+// handleHTTPSubject answers on the http subjects
+func (h *Handler) handleHTTPSubject(c *handlerConn, msg *nats.Msg) {
+	if authorize(msg, h.cfg.Load(), c.name) {
+		h.handleHTTP(msg)
+	}
+}
+
+func (h *Handler) handleDiscover(c *handlerConn, msg *nats.Msg) {
+	cfg := h.cfg.Load()
+	if ok, err := selected(msg.Header, cfg); !ok {
+		if err != nil && cfg.Debug {
+			slog.Debug("invalid selector", "err", err)
+		}
+		return
+	}
+	if !authorize(msg, cfg, c.name) {
+		return
+	}
+	err := handleDiscover(msg, cfg)
+	if err != nil && cfg.Debug {
+		slog.Debug("error handling discover", "err", err)
+	}
+}
+
+func (h *Handler) handleInfo(c *handlerConn, msg *nats.Msg) {
+	cfg := h.cfg.Load()
+	if !authorize(msg, cfg, c.name) {
+		return
+	}
+	err := handleInfo(msg, cfg)
+	if err != nil && cfg.Debug {
+		slog.Debug("error handling info", "err", err)
+	}
+}
+
+func (h *Handler) handlePprof(c *handlerConn, msg *nats.Msg) {
+	cfg := h.cfg.Load()
+	if !authorize(msg, cfg, c.name) {
+		return
+	}
+	// profiles can take a while, don't block the subscription
+	go func() {
+		err := handlePprof(c.nc, msg, cfg)
+		if err != nil && cfg.Debug {
+			slog.Debug("error handling pprof", "err", err)
+		}
+	}()
+}
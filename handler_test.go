@@ -0,0 +1,199 @@
+package promnats
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestHandler_handlers(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *options
+		want []string
+	}{
+		{"plain", &options{RootSubject: "metrics", ID: "a.b", Subjects: []string{"", "a", "a.b"}},
+			[]string{"metrics", "metrics.$discover", "metrics.a", "metrics.a.b", "metrics.a.b.info"}},
+		{"pprof", &options{RootSubject: "metrics", ID: "a", Subjects: []string{"", "a"}, Pprof: true},
+			[]string{"metrics", "metrics.$discover", "metrics.a", "metrics.a.info", "metrics.a.pprof.*"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{}
+			got := []string{}
+			for subj := range h.handlers(tt.cfg) {
+				got = append(got, subj)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("handlers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewHandler_requestsDuringStartup(t *testing.T) {
+	// the flushers sending the subscriptions must run while the handler subscribes
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+	s := runServer(t)
+	nc := connect(t, s)
+	pub := connect(t, s)
+	// the more connections, the longer the first subscriptions are live before all are made
+	ncs := []*nats.Conn{nc}
+	for i := 0; i < 20; i++ {
+		ncs = append(ncs, connect(t, s))
+	}
+
+	stop := make(chan struct{})
+	flooded := make(chan struct{})
+	go func() {
+		defer close(flooded)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			for _, subj := range []string{"metrics", "metrics.a", "metrics.a.b", "metrics.$discover", "metrics.a.b.info"} {
+				msg := nats.NewMsg(subj)
+				msg.Reply = "_INBOX.ignored"
+				pub.PublishMsg(msg)
+			}
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		h, err := NewHandlerMulti(ncs, WithID("a.b"), WithCollisionPolicy(CollisionIgnore, time.Second), WithPprof(0))
+		if err != nil {
+			t.Fatalf("NewHandlerMulti() error = %v", err)
+		}
+		h.Close()
+	}
+	close(stop)
+	<-flooded
+	pub.Flush()
+
+	h, err := NewHandler(nc, WithID("a.b"), WithCollisionPolicy(CollisionIgnore, time.Second))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer h.Close()
+	nc.Flush()
+	resp, err := pub.Request("metrics.a.b", nil, 5*time.Second)
+	if err != nil {
+		t.Fatalf("request error = %v", err)
+	}
+	if got := resp.Header.Get(HeaderPnID); got != "a.b" {
+		t.Errorf("reply %s = %q, want a.b", HeaderPnID, got)
+	}
+}
+
+func TestHandler_SetParts(t *testing.T) {
+	s := runServer(t)
+	nc := connect(t, s)
+	client := connect(t, s)
+	announced, err := client.SubscribeSync(AnnounceSubject("metrics"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Flush()
+
+	h, err := NewHandler(nc, WithID("a.b"), WithCollisionPolicy(CollisionIgnore, time.Second))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer h.Close()
+
+	// same id, nothing changes
+	if err := h.SetID("a.b"); err != nil {
+		t.Fatalf("SetID() error = %v", err)
+	}
+	if _, err := announced.NextMsg(50 * time.Millisecond); err == nil {
+		t.Error("SetID() with the same id announced a change")
+	}
+
+	if err := h.SetID("a.c"); err != nil {
+		t.Fatalf("SetID() error = %v", err)
+	}
+	if got := h.ID(); got != "a.c" {
+		t.Errorf("ID() = %q, want a.c", got)
+	}
+	nc.Flush()
+
+	msg, err := announced.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("no announcement: %v", err)
+	}
+	if got := msg.Header.Get(HeaderPreviousID); got != "a.b" {
+		t.Errorf("announced %s = %q, want a.b", HeaderPreviousID, got)
+	}
+	if got := msg.Header.Get(HeaderPnID); got != "a.c" {
+		t.Errorf("announced %s = %q, want a.c", HeaderPnID, got)
+	}
+
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{"metrics.a.c", "a.c"},
+		{"metrics.a.c.info", "a.c"},
+		{"metrics.a", "a.c"},
+		{"metrics.a.b", ""},
+		{"metrics.a.b.info", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			resp, err := client.Request(tt.subject, nil, time.Second)
+			if tt.want == "" {
+				if !errors.Is(err, nats.ErrNoResponders) {
+					t.Errorf("request error = %v, want no responders", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("request error = %v", err)
+			}
+			if got := resp.Header.Get(HeaderPnID); got != tt.want {
+				t.Errorf("reply %s = %q, want %q", HeaderPnID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_SetParts_rollback(t *testing.T) {
+	s := runServer(t)
+	a := connect(t, s)
+	b := connect(t, s)
+	client := connect(t, s)
+
+	h, err := NewHandlerMulti([]*nats.Conn{a, b}, WithID("a.b"), WithCollisionPolicy(CollisionIgnore, time.Second))
+	if err != nil {
+		t.Fatalf("NewHandlerMulti() error = %v", err)
+	}
+	defer h.Close()
+
+	// subscribing on b fails after a has subscribed
+	b.Close()
+	if err := h.SetID("x.y"); err == nil {
+		t.Fatal("SetID() error = nil, want error")
+	}
+	if got := h.ID(); got != "a.b" {
+		t.Errorf("ID() = %q, want a.b", got)
+	}
+	a.Flush()
+	if _, err := client.Request("metrics.x.y", nil, time.Second); !errors.Is(err, nats.ErrNoResponders) {
+		t.Errorf("request on the new id error = %v, want no responders", err)
+	}
+	resp, err := client.Request("metrics.a.b", nil, time.Second)
+	if err != nil {
+		t.Fatalf("request on the old id error = %v", err)
+	}
+	if got := resp.Header.Get(HeaderPnID); got != "a.b" {
+		t.Errorf("reply %s = %q, want a.b", HeaderPnID, got)
+	}
+}
@@ -0,0 +1,41 @@
+package promnats
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// HeaderScrapeInterval is the preferred scrape interval of an instance as a duration like 15s
+	HeaderScrapeInterval = "Promnats-Scrape-Interval"
+	// HeaderScrapeTimeout is the preferred scrape timeout of an instance as a duration like 10s
+	HeaderScrapeTimeout = "Promnats-Scrape-Timeout"
+)
+
+// WithScrapeHints advertises the preferred scrape interval and timeout.
+// Timeout is optional, use 0 to leave it out, but must not be longer than interval.
+func WithScrapeHints(interval, timeout time.Duration) Option {
+	return func(o *options) error {
+		if interval <= 0 || timeout < 0 {
+			return errors.New("interval must be positive and timeout not negative")
+		}
+		if timeout > interval {
+			return errors.New("timeout must not be longer than interval")
+		}
+		o.ScrapeInterval = interval
+		o.ScrapeTimeout = timeout
+		return nil
+	}
+}
+
+// setHintHeaders sets the scrape hint headers in h
+func setHintHeaders(h nats.Header, cfg *options) {
+	if cfg.ScrapeInterval > 0 {
+		h.Set(HeaderScrapeInterval, cfg.ScrapeInterval.String())
+	}
+	if cfg.ScrapeTimeout > 0 {
+		h.Set(HeaderScrapeTimeout, cfg.ScrapeTimeout.String())
+	}
+}
@@ -0,0 +1,38 @@
+package promnats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestWithScrapeHints(t *testing.T) {
+	tests := []struct {
+		name         string
+		interval     time.Duration
+		timeout      time.Duration
+		wantInterval string
+		wantTimeout  string
+		wantErr      bool
+	}{
+		{"both", time.Minute, 10 * time.Second, "1m0s", "10s", false},
+		{"no timeout", 5 * time.Second, 0, "5s", "", false},
+		{"timeout too long", 5 * time.Second, 10 * time.Second, "", "", true},
+		{"no interval", 0, 0, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &options{}
+			err := WithScrapeHints(tt.interval, tt.timeout)(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WithScrapeHints() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			h := nats.Header{}
+			setHintHeaders(h, cfg)
+			if h.Get(HeaderScrapeInterval) != tt.wantInterval || h.Get(HeaderScrapeTimeout) != tt.wantTimeout {
+				t.Errorf("setHintHeaders() = %v", h)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package promnats
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// HeaderQuery is used as the raw query of requests to a http.Handler
+	HeaderQuery = "Promnats-Query"
+	// HeaderMethod is used as the method of requests to a http.Handler.
+	// Defaults to GET, or POST if the request has a body.
+	HeaderMethod = "Promnats-Method"
+
+	httpToken = "http"
+)
+
+// WithHTTPHandler serves metrics requests using handler instead of gathering
+// from the default registry. Requests on <root>.<id>.http.> are also served
+// with the rest of the subject as path, so that a.b becomes /a/b.
+//
+//	promnats.WithHTTPHandler(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+func WithHTTPHandler(handler http.Handler) Option {
+	return func(o *options) error {
+		if handler == nil {
+			return errors.New("handler must not be nil")
+		}
+		o.HTTPHandler = handler
+		return nil
+	}
+}
+
+// HTTPSubject returns the subject that serves path of the http.Handler for id.
+func HTTPSubject(root, id, path string) string {
+	path = strings.ReplaceAll(strings.Trim(path, "/"), "/", ".")
+	return strings.Join([]string{root, id, httpToken, path}, ".")
+}
+
+// responseWriter collects everything written by a http.Handler
+type responseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.body.Write(p)
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// newHTTPRequest converts msg into a request for path
+func newHTTPRequest(ctx context.Context, msg *nats.Msg, path string) (*http.Request, error) {
+	method := msg.Header.Get(HeaderMethod)
+	if method == "" {
+		method = http.MethodGet
+		if len(msg.Data) > 0 {
+			method = http.MethodPost
+		}
+	}
+	u := &url.URL{Path: path, RawQuery: msg.Header.Get(HeaderQuery)}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(msg.Data))
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range msg.Header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	return req, nil
+}
+
+func handleHTTP(msg *nats.Msg, cfg *options) error {
+	path := "/"
+	prefix := strings.Join([]string{cfg.RootSubject, cfg.ID, httpToken, ""}, ".")
+	if strings.HasPrefix(msg.Subject, prefix) {
+		path += strings.ReplaceAll(strings.TrimPrefix(msg.Subject, prefix), ".", "/")
+	}
+	req, err := newHTTPRequest(context.Background(), msg, path)
+	if err != nil {
+		return respondStatus(msg, cfg.Header, &statusError{code: 400, description: err.Error()})
+	}
+
+	rw := &responseWriter{header: http.Header{}}
+	cfg.HTTPHandler.ServeHTTP(rw, req)
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	resp := nats.NewMsg(msg.Subject)
+	for k, vs := range rw.header {
+		resp.Header[k] = vs
+	}
+	for k, vs := range cfg.Header {
+		resp.Header[k] = append([]string(nil), vs...)
+	}
+	if rw.status >= 400 {
+		resp.Header.Set(hdrStatus, strconv.Itoa(rw.status))
+		resp.Header.Set(hdrDescription, http.StatusText(rw.status))
+	}
+	resp.Data = rw.body.Bytes()
+	return msg.RespondMsg(resp)
+}
@@ -0,0 +1,56 @@
+package promnats
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+)
+
+func Test_newHTTPRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     nats.Header
+		data       string
+		path       string
+		wantMethod string
+		wantURL    string
+	}{
+		{"get", nats.Header{"Accept": {"text/plain"}}, "", "/", http.MethodGet, "/"},
+		{"post", nats.Header{}, "body", "/a/b", http.MethodPost, "/a/b"},
+		{"query", nats.Header{HeaderQuery: {"target=x&module=y"}}, "", "/probe", http.MethodGet, "/probe?target=x&module=y"},
+		{"method", nats.Header{HeaderMethod: {http.MethodPut}}, "", "/", http.MethodPut, "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &nats.Msg{Header: tt.header, Data: []byte(tt.data)}
+			req, err := newHTTPRequest(context.Background(), msg, tt.path)
+			if err != nil {
+				t.Fatalf("newHTTPRequest() error = %v", err)
+			}
+			if req.Method != tt.wantMethod {
+				t.Errorf("newHTTPRequest() method = %v, want %v", req.Method, tt.wantMethod)
+			}
+			if got := req.URL.String(); got != tt.wantURL {
+				t.Errorf("newHTTPRequest() url = %v, want %v", got, tt.wantURL)
+			}
+			for k := range tt.header {
+				if req.Header.Get(k) != tt.header.Get(k) {
+					t.Errorf("newHTTPRequest() header %s = %v, want %v", k, req.Header.Get(k), tt.header.Get(k))
+				}
+			}
+			body, _ := io.ReadAll(req.Body)
+			if string(body) != tt.data {
+				t.Errorf("newHTTPRequest() body = %q, want %q", body, tt.data)
+			}
+		})
+	}
+}
+
+func TestHTTPSubject(t *testing.T) {
+	if got, want := HTTPSubject("metrics", "a.b", "/probe/x"), "metrics.a.b.http.probe.x"; got != want {
+		t.Errorf("HTTPSubject() = %v, want %v", got, want)
+	}
+}
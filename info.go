@@ -0,0 +1,145 @@
+package promnats
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const infoToken = "info"
+
+// startTime is close enough to process start for uptime reporting
+var startTime = time.Now()
+
+// Info describes a running instance. It is returned as JSON on <root>.<id>.info
+type Info struct {
+	ID            string            `json:"id"`
+	Subjects      []string          `json:"subjects"`
+	Path          string            `json:"path,omitempty"`
+	Version       string            `json:"version,omitempty"`
+	Revision      string            `json:"revision,omitempty"`
+	RevisionTime  string            `json:"revision_time,omitempty"`
+	Modified      bool              `json:"modified,omitempty"`
+	GoVersion     string            `json:"go_version"`
+	StartTime     time.Time         `json:"start_time"`
+	UptimeSeconds float64           `json:"uptime_seconds"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+// HeaderLabelPrefix is the prefix of the headers that carry the metadata
+// of an instance, Promnats-Label-<name>: <value>
+const HeaderLabelPrefix = "Promnats-Label-"
+
+const (
+	// HeaderAppVersion is the Info.Version of the instance, sent with every reply
+	HeaderAppVersion = "Promnats-App-Version"
+	// HeaderAppRevision is the Info.Revision of the instance, sent with every reply
+	HeaderAppRevision = "Promnats-App-Revision"
+)
+
+// WithMetadata adds user metadata that is reported by the instance in its info
+// and sent as HeaderLabelPrefix headers with every reply.
+func WithMetadata(md map[string]string) Option {
+	return func(o *options) error {
+		if o.Metadata == nil {
+			o.Metadata = make(map[string]string, len(md))
+		}
+		for k, v := range md {
+			if k == "" {
+				return errors.New("metadata key must not be empty")
+			}
+			if strings.ContainsAny(k, ": \r\n\t") || strings.ContainsAny(v, "\r\n") {
+				return fmt.Errorf("invalid metadata '%s'", k)
+			}
+			o.Metadata[k] = v
+		}
+		return nil
+	}
+}
+
+// labelHeader returns the header for metadata key.
+// Characters not allowed in header names are replaced with _
+func labelHeader(key string) string {
+	b := []byte(key)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0) {
+			b[i] = '_'
+		}
+	}
+	return HeaderLabelPrefix + string(b)
+}
+
+// WithVersion overrides the version read from the build info.
+// Useful when the version is set using -ldflags
+func WithVersion(version string) Option {
+	return func(o *options) error {
+		o.Version = version
+		return nil
+	}
+}
+
+// InfoSubject returns the subject that answers with Info for id.
+func InfoSubject(root, id string) string {
+	return strings.Join([]string{root, id, infoToken}, ".")
+}
+
+func buildInfo(cfg *options) Info {
+	info := Info{
+		ID:            cfg.ID,
+		GoVersion:     runtime.Version(),
+		StartTime:     startTime,
+		UptimeSeconds: time.Since(startTime).Seconds(),
+		Metadata:      cfg.Metadata,
+	}
+	for _, s := range cfg.Subjects {
+		info.Subjects = append(info.Subjects, cfg.subject(s))
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info.Path = bi.Main.Path
+		info.Version = bi.Main.Version
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				info.Revision = s.Value
+			case "vcs.time":
+				info.RevisionTime = s.Value
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	if cfg.Version != "" {
+		info.Version = cfg.Version
+	}
+	return info
+}
+
+// setInfoHeaders sets the version and revision of the instance in h,
+// so that discovery doesn't need to ask for the info
+func setInfoHeaders(h nats.Header, cfg *options) {
+	info := buildInfo(cfg)
+	if info.Version != "" {
+		h.Set(HeaderAppVersion, info.Version)
+	}
+	if info.Revision != "" {
+		h.Set(HeaderAppRevision, info.Revision)
+	}
+}
+
+func handleInfo(msg *nats.Msg, cfg *options) error {
+	data, err := json.Marshal(buildInfo(cfg))
+	if err != nil {
+		return respondStatus(msg, cfg.Header, err)
+	}
+	resp := nats.NewMsg(msg.Subject)
+	resp.Header = copyHeader(cfg.Header)
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Data = data
+	return msg.RespondMsg(resp)
+}
@@ -0,0 +1,78 @@
+package promnats
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+)
+
+func Test_buildInfo(t *testing.T) {
+	cfg := &options{RootSubject: "metrics", ID: "a.b"}
+	for _, o := range []Option{WithParts("a", "b"), WithMetadata(map[string]string{"team": "ops"}), WithVersion("1.2.3")} {
+		if err := o(cfg); err != nil {
+			t.Fatalf("Option() = %v", err)
+		}
+	}
+	got := buildInfo(cfg)
+	if want := []string{"metrics", "metrics.a", "metrics.a.b"}; !reflect.DeepEqual(got.Subjects, want) {
+		t.Errorf("buildInfo().Subjects = %v, want %v", got.Subjects, want)
+	}
+	if got.Version != "1.2.3" {
+		t.Errorf("buildInfo().Version = %v, want %v", got.Version, "1.2.3")
+	}
+	if got.GoVersion != runtime.Version() {
+		t.Errorf("buildInfo().GoVersion = %v, want %v", got.GoVersion, runtime.Version())
+	}
+	if got.Metadata["team"] != "ops" {
+		t.Errorf("buildInfo().Metadata = %v", got.Metadata)
+	}
+}
+
+func Test_WithMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		md      map[string]string
+		wantErr bool
+	}{
+		{"valid", map[string]string{"team": "ops", "app.kubernetes.io/name": "x"}, false},
+		{"empty key", map[string]string{"": "x"}, true},
+		{"colon", map[string]string{"a:b": "x"}, true},
+		{"newline value", map[string]string{"a": "x\ny"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := WithMetadata(tt.md)(&options{}); (err != nil) != tt.wantErr {
+				t.Errorf("WithMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_labelHeader(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"team", "Promnats-Label-team"},
+		{"app.kubernetes.io/name", "Promnats-Label-app.kubernetes.io_name"},
+		{"a(b)", "Promnats-Label-a_b_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := labelHeader(tt.key); got != tt.want {
+				t.Errorf("labelHeader() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_setInfoHeaders(t *testing.T) {
+	cfg := &options{RootSubject: "metrics", ID: "a", Version: "1.2.3"}
+	h := nats.Header{}
+	setInfoHeaders(h, cfg)
+	if got := h.Get(HeaderAppVersion); got != "1.2.3" {
+		t.Errorf("%s = %q, want 1.2.3", HeaderAppVersion, got)
+	}
+}
@@ -0,0 +1,184 @@
+package promnats
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// JetStreamOpts configures the collector returned by NewJetStreamCollector
+type JetStreamOpts struct {
+	// Streams are patterns, as used by path.Match, of the streams to collect.
+	// All streams are collected if empty.
+	Streams []string
+	// Consumers are patterns of the consumers to collect in the matching streams.
+	// All consumers are collected if empty.
+	Consumers []string
+	// NoConsumers turns off the consumer metrics
+	NoConsumers bool
+	// CacheTTL is how long the state is reused before asking the JetStream API again.
+	// Defaults to 10s, use a negative value to always ask.
+	CacheTTL time.Duration
+	// Timeout for the JetStream API requests, defaults to 5s
+	Timeout time.Duration
+	// Labels are added to every metric
+	Labels prometheus.Labels
+}
+
+// jetStreamCollector collects stream and consumer state from the JetStream API
+type jetStreamCollector struct {
+	js   jetstream.JetStream
+	opts JetStreamOpts
+
+	mu       sync.Mutex
+	cached   []prometheus.Metric
+	cachedAt time.Time
+
+	up              *prometheus.Desc
+	streamMsgs      *prometheus.Desc
+	streamBytes     *prometheus.Desc
+	streamFirstSeq  *prometheus.Desc
+	streamLastSeq   *prometheus.Desc
+	streamConsumers *prometheus.Desc
+	consPending     *prometheus.Desc
+	consAckPending  *prometheus.Desc
+	consRedelivered *prometheus.Desc
+	consWaiting     *prometheus.Desc
+	consLastActive  *prometheus.Desc
+}
+
+// NewJetStreamCollector returns a collector for the state of the streams
+// and consumers matching opts. The JetStream API is asked on Collect,
+// at most once every opts.CacheTTL.
+func NewJetStreamCollector(nc *nats.Conn, opts JetStreamOpts) (prometheus.Collector, error) {
+	for _, p := range append(append([]string{}, opts.Streams...), opts.Consumers...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %w", p, err)
+		}
+	}
+	if opts.CacheTTL == 0 {
+		opts.CacheTTL = 10 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, err
+	}
+	desc := func(name, help string, variable ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("nats", "jetstream", name), help, variable, opts.Labels)
+	}
+	return &jetStreamCollector{
+		js:              js,
+		opts:            opts,
+		up:              desc("up", "1 if the JetStream API answered on the last request."),
+		streamMsgs:      desc("stream_messages", "Number of messages stored in the stream.", "stream"),
+		streamBytes:     desc("stream_bytes", "Number of bytes stored in the stream.", "stream"),
+		streamFirstSeq:  desc("stream_first_seq", "Sequence of the first message in the stream.", "stream"),
+		streamLastSeq:   desc("stream_last_seq", "Sequence of the last message in the stream.", "stream"),
+		streamConsumers: desc("stream_consumers", "Number of consumers on the stream.", "stream"),
+		consPending:     desc("consumer_num_pending", "Number of messages matching the consumer not yet delivered.", "stream", "consumer"),
+		consAckPending:  desc("consumer_num_ack_pending", "Number of messages delivered but not yet acknowledged.", "stream", "consumer"),
+		consRedelivered: desc("consumer_num_redelivered", "Number of messages redelivered and not yet acknowledged.", "stream", "consumer"),
+		consWaiting:     desc("consumer_num_waiting", "Number of active pull requests.", "stream", "consumer"),
+		consLastActive:  desc("consumer_last_active_timestamp_seconds", "Time of the last delivery to the consumer.", "stream", "consumer"),
+	}, nil
+}
+
+// Describe implements prometheus.Collector
+func (c *jetStreamCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{c.up, c.streamMsgs, c.streamBytes, c.streamFirstSeq, c.streamLastSeq,
+		c.streamConsumers, c.consPending, c.consAckPending, c.consRedelivered, c.consWaiting, c.consLastActive} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *jetStreamCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cached == nil || time.Since(c.cachedAt) >= c.opts.CacheTTL {
+		c.cached = c.collect()
+		c.cachedAt = time.Now()
+	}
+	for _, m := range c.cached {
+		ch <- m
+	}
+}
+
+// collect asks the JetStream API for the state
+func (c *jetStreamCollector) collect() []prometheus.Metric {
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+	defer cancel()
+
+	out := []prometheus.Metric{}
+	gauge := func(d *prometheus.Desc, v float64, labels ...string) {
+		out = append(out, prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, labels...))
+	}
+	up := 1.0
+	streams := c.js.ListStreams(ctx)
+	for si := range streams.Info() {
+		name := si.Config.Name
+		if !matchAny(c.opts.Streams, name) {
+			continue
+		}
+		gauge(c.streamMsgs, float64(si.State.Msgs), name)
+		gauge(c.streamBytes, float64(si.State.Bytes), name)
+		gauge(c.streamFirstSeq, float64(si.State.FirstSeq), name)
+		gauge(c.streamLastSeq, float64(si.State.LastSeq), name)
+		gauge(c.streamConsumers, float64(si.State.Consumers), name)
+		if c.opts.NoConsumers || si.State.Consumers == 0 {
+			continue
+		}
+		stream, err := c.js.Stream(ctx, name)
+		if err != nil {
+			slog.Warn("error getting stream", "stream", name, "err", err)
+			up = 0
+			continue
+		}
+		consumers := stream.ListConsumers(ctx)
+		for ci := range consumers.Info() {
+			if !matchAny(c.opts.Consumers, ci.Name) {
+				continue
+			}
+			gauge(c.consPending, float64(ci.NumPending), name, ci.Name)
+			gauge(c.consAckPending, float64(ci.NumAckPending), name, ci.Name)
+			gauge(c.consRedelivered, float64(ci.NumRedelivered), name, ci.Name)
+			gauge(c.consWaiting, float64(ci.NumWaiting), name, ci.Name)
+			if ci.Delivered.Last != nil {
+				gauge(c.consLastActive, float64(ci.Delivered.Last.UnixNano())/1e9, name, ci.Name)
+			}
+		}
+		if err := consumers.Err(); err != nil {
+			slog.Warn("error listing consumers", "stream", name, "err", err)
+			up = 0
+		}
+	}
+	if err := streams.Err(); err != nil {
+		slog.Warn("error listing streams", "err", err)
+		up = 0
+	}
+	gauge(c.up, up)
+	return out
+}
+
+// matchAny returns true if name matches any of patterns or patterns is empty
+func matchAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package promnats
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_matchAny(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		name     string
+		want     bool
+	}{
+		{nil, "ORDERS", true},
+		{[]string{"ORDERS"}, "ORDERS", true},
+		{[]string{"KV_*", "ORD*"}, "ORDERS", true},
+		{[]string{"KV_*"}, "ORDERS", false},
+	}
+	for _, tt := range tests {
+		if got := matchAny(tt.patterns, tt.name); got != tt.want {
+			t.Errorf("matchAny(%v, %s) = %v, want %v", tt.patterns, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewJetStreamCollector(t *testing.T) {
+	if _, err := NewJetStreamCollector(nil, JetStreamOpts{Streams: []string{"["}}); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+// jetStreamNames are the families compared, the byte counts depend on the storage
+var jetStreamNames = []string{
+	"nats_jetstream_up", "nats_jetstream_stream_messages", "nats_jetstream_stream_last_seq",
+	"nats_jetstream_stream_consumers", "nats_jetstream_consumer_num_pending", "nats_jetstream_consumer_num_ack_pending",
+}
+
+func TestJetStreamCollector_collect(t *testing.T) {
+	s := runJetStreamServer(t)
+	nc := connect(t, s)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, cfg := range []jetstream.StreamConfig{
+		{Name: "ORDERS", Subjects: []string{"orders.>"}},
+		{Name: "AUDIT", Subjects: []string{"audit.>"}},
+	} {
+		if _, err := js.CreateStream(ctx, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"process", "archive"} {
+		if _, err := js.CreateConsumer(ctx, "ORDERS", jetstream.ConsumerConfig{Durable: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, subj := range []string{"orders.1", "orders.2", "audit.1"} {
+		if _, err := js.Publish(ctx, subj, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts JetStreamOpts
+		want string
+	}{
+		{"all", JetStreamOpts{Labels: prometheus.Labels{"cluster": "a"}}, `
+# HELP nats_jetstream_up 1 if the JetStream API answered on the last request.
+# TYPE nats_jetstream_up gauge
+nats_jetstream_up{cluster="a"} 1
+# HELP nats_jetstream_stream_messages Number of messages stored in the stream.
+# TYPE nats_jetstream_stream_messages gauge
+nats_jetstream_stream_messages{cluster="a",stream="AUDIT"} 1
+nats_jetstream_stream_messages{cluster="a",stream="ORDERS"} 2
+# HELP nats_jetstream_stream_last_seq Sequence of the last message in the stream.
+# TYPE nats_jetstream_stream_last_seq gauge
+nats_jetstream_stream_last_seq{cluster="a",stream="AUDIT"} 1
+nats_jetstream_stream_last_seq{cluster="a",stream="ORDERS"} 2
+# HELP nats_jetstream_stream_consumers Number of consumers on the stream.
+# TYPE nats_jetstream_stream_consumers gauge
+nats_jetstream_stream_consumers{cluster="a",stream="AUDIT"} 0
+nats_jetstream_stream_consumers{cluster="a",stream="ORDERS"} 2
+# HELP nats_jetstream_consumer_num_pending Number of messages matching the consumer not yet delivered.
+# TYPE nats_jetstream_consumer_num_pending gauge
+nats_jetstream_consumer_num_pending{cluster="a",consumer="archive",stream="ORDERS"} 2
+nats_jetstream_consumer_num_pending{cluster="a",consumer="process",stream="ORDERS"} 2
+# HELP nats_jetstream_consumer_num_ack_pending Number of messages delivered but not yet acknowledged.
+# TYPE nats_jetstream_consumer_num_ack_pending gauge
+nats_jetstream_consumer_num_ack_pending{cluster="a",consumer="archive",stream="ORDERS"} 0
+nats_jetstream_consumer_num_ack_pending{cluster="a",consumer="process",stream="ORDERS"} 0
+`},
+		{"filtered", JetStreamOpts{Streams: []string{"ORD*"}, Consumers: []string{"proc*"}}, `
+# HELP nats_jetstream_up 1 if the JetStream API answered on the last request.
+# TYPE nats_jetstream_up gauge
+nats_jetstream_up 1
+# HELP nats_jetstream_stream_messages Number of messages stored in the stream.
+# TYPE nats_jetstream_stream_messages gauge
+nats_jetstream_stream_messages{stream="ORDERS"} 2
+# HELP nats_jetstream_stream_last_seq Sequence of the last message in the stream.
+# TYPE nats_jetstream_stream_last_seq gauge
+nats_jetstream_stream_last_seq{stream="ORDERS"} 2
+# HELP nats_jetstream_stream_consumers Number of consumers on the stream.
+# TYPE nats_jetstream_stream_consumers gauge
+nats_jetstream_stream_consumers{stream="ORDERS"} 2
+# HELP nats_jetstream_consumer_num_pending Number of messages matching the consumer not yet delivered.
+# TYPE nats_jetstream_consumer_num_pending gauge
+nats_jetstream_consumer_num_pending{consumer="process",stream="ORDERS"} 2
+# HELP nats_jetstream_consumer_num_ack_pending Number of messages delivered but not yet acknowledged.
+# TYPE nats_jetstream_consumer_num_ack_pending gauge
+nats_jetstream_consumer_num_ack_pending{consumer="process",stream="ORDERS"} 0
+`},
+		{"no consumers", JetStreamOpts{Streams: []string{"ORDERS"}, NoConsumers: true}, `
+# HELP nats_jetstream_up 1 if the JetStream API answered on the last request.
+# TYPE nats_jetstream_up gauge
+nats_jetstream_up 1
+# HELP nats_jetstream_stream_messages Number of messages stored in the stream.
+# TYPE nats_jetstream_stream_messages gauge
+nats_jetstream_stream_messages{stream="ORDERS"} 2
+# HELP nats_jetstream_stream_last_seq Sequence of the last message in the stream.
+# TYPE nats_jetstream_stream_last_seq gauge
+nats_jetstream_stream_last_seq{stream="ORDERS"} 2
+# HELP nats_jetstream_stream_consumers Number of consumers on the stream.
+# TYPE nats_jetstream_stream_consumers gauge
+nats_jetstream_stream_consumers{stream="ORDERS"} 2
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewJetStreamCollector(nc, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := testutil.CollectAndCompare(c, strings.NewReader(tt.want), jetStreamNames...); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestJetStreamCollector_cache(t *testing.T) {
+	s := runJetStreamServer(t)
+	nc := connect(t, s)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		stream string
+		ttl    time.Duration
+		want   float64
+	}{
+		{"cached", "CACHED", time.Hour, 1},
+		{"always asks", "UNCACHED", -1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := js.CreateStream(ctx, jetstream.StreamConfig{Name: tt.stream}); err != nil {
+				t.Fatal(err)
+			}
+			c, err := NewJetStreamCollector(nc, JetStreamOpts{Streams: []string{tt.stream}, CacheTTL: tt.ttl, NoConsumers: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := js.Publish(ctx, tt.stream, nil); err != nil {
+				t.Fatal(err)
+			}
+			testutil.CollectAndCount(c)
+			if _, err := js.Publish(ctx, tt.stream, nil); err != nil {
+				t.Fatal(err)
+			}
+			// the state of the first collect is reused within the ttl
+			want := fmt.Sprintf(`
+# HELP nats_jetstream_stream_messages Number of messages stored in the stream.
+# TYPE nats_jetstream_stream_messages gauge
+nats_jetstream_stream_messages{stream="%s"} %v
+`, tt.stream, tt.want)
+			if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nats_jetstream_stream_messages"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestJetStreamCollector_down(t *testing.T) {
+	// a server without JetStream fails the API requests
+	s := runServer(t)
+	c, err := NewJetStreamCollector(connect(t, s), JetStreamOpts{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `
+# HELP nats_jetstream_up 1 if the JetStream API answered on the last request.
+# TYPE nats_jetstream_up gauge
+nats_jetstream_up 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nats_jetstream_up"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,176 @@
+package promnats
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// ContentTypeJSON is the content type of the JSON exposition format
+const ContentTypeJSON = "application/json"
+
+// JSONFamily is a metric family in the JSON exposition format
+type JSONFamily struct {
+	Name    string       `json:"name"`
+	Help    string       `json:"help,omitempty"`
+	Type    string       `json:"type"`
+	Metrics []JSONMetric `json:"metrics"`
+}
+
+// JSONMetric is a single metric, the value is set in one of
+// Value, Histogram or Summary depending on the type of the family.
+type JSONMetric struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Value       *JSONFloat        `json:"value,omitempty"`
+	Histogram   *JSONHistogram    `json:"histogram,omitempty"`
+	Summary     *JSONSummary      `json:"summary,omitempty"`
+	TimestampMs int64             `json:"timestamp_ms,omitempty"`
+}
+
+// JSONHistogram has cumulative buckets, the same way as the text format.
+type JSONHistogram struct {
+	Count   uint64       `json:"count"`
+	Sum     JSONFloat    `json:"sum"`
+	Buckets []JSONBucket `json:"buckets"`
+}
+
+type JSONBucket struct {
+	UpperBound JSONFloat `json:"le"`
+	Count      uint64    `json:"count"`
+}
+
+type JSONSummary struct {
+	Count     uint64         `json:"count"`
+	Sum       JSONFloat      `json:"sum"`
+	Quantiles []JSONQuantile `json:"quantiles"`
+}
+
+type JSONQuantile struct {
+	Quantile JSONFloat `json:"quantile"`
+	Value    JSONFloat `json:"value"`
+}
+
+// JSONFloat is encoded as a number, or as "NaN", "+Inf" and "-Inf"
+// strings since those are not valid JSON numbers.
+type JSONFloat float64
+
+func (f JSONFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	switch {
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(v, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Inf"`), nil
+	}
+	return strconv.AppendFloat(nil, v, 'g', -1, 64), nil
+}
+
+func (f *JSONFloat) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*f = JSONFloat(v)
+	return nil
+}
+
+func jsonFloat(v float64) *JSONFloat {
+	f := JSONFloat(v)
+	return &f
+}
+
+// ToJSON converts gathered metric families to the JSON exposition format
+func ToJSON(mfs []*dto.MetricFamily) []JSONFamily {
+	out := make([]JSONFamily, 0, len(mfs))
+	for _, mf := range mfs {
+		jf := JSONFamily{
+			Name:    mf.GetName(),
+			Help:    mf.GetHelp(),
+			Type:    strings.ToLower(mf.GetType().String()),
+			Metrics: make([]JSONMetric, 0, len(mf.GetMetric())),
+		}
+		for _, m := range mf.GetMetric() {
+			jm := JSONMetric{TimestampMs: m.GetTimestampMs()}
+			if len(m.GetLabel()) > 0 {
+				jm.Labels = make(map[string]string, len(m.GetLabel()))
+				for _, l := range m.GetLabel() {
+					jm.Labels[l.GetName()] = l.GetValue()
+				}
+			}
+			switch {
+			case m.Counter != nil:
+				jm.Value = jsonFloat(m.GetCounter().GetValue())
+			case m.Gauge != nil:
+				jm.Value = jsonFloat(m.GetGauge().GetValue())
+			case m.Untyped != nil:
+				jm.Value = jsonFloat(m.GetUntyped().GetValue())
+			case m.Histogram != nil:
+				h := m.GetHistogram()
+				jh := &JSONHistogram{Count: h.GetSampleCount(), Sum: JSONFloat(h.GetSampleSum())}
+				for _, b := range h.GetBucket() {
+					jh.Buckets = append(jh.Buckets, JSONBucket{UpperBound: JSONFloat(b.GetUpperBound()), Count: b.GetCumulativeCount()})
+				}
+				if n := len(jh.Buckets); n == 0 || !math.IsInf(float64(jh.Buckets[n-1].UpperBound), 1) {
+					jh.Buckets = append(jh.Buckets, JSONBucket{UpperBound: JSONFloat(math.Inf(1)), Count: h.GetSampleCount()})
+				}
+				jm.Histogram = jh
+			case m.Summary != nil:
+				s := m.GetSummary()
+				js := &JSONSummary{Count: s.GetSampleCount(), Sum: JSONFloat(s.GetSampleSum()), Quantiles: []JSONQuantile{}}
+				for _, q := range s.GetQuantile() {
+					js.Quantiles = append(js.Quantiles, JSONQuantile{Quantile: JSONFloat(q.GetQuantile()), Value: JSONFloat(q.GetValue())})
+				}
+				jm.Summary = js
+			}
+			jf.Metrics = append(jf.Metrics, jm)
+		}
+		out = append(out, jf)
+	}
+	return out
+}
+
+// EncodeJSON writes gathered metric families to w in the JSON exposition format
+func EncodeJSON(w io.Writer, mfs []*dto.MetricFamily) error {
+	return json.NewEncoder(w).Encode(ToJSON(mfs))
+}
+
+// acceptsJSON returns true if the Accept header prefers ContentTypeJSON,
+// that is it has the highest q-value. Ties go to the media range listed first.
+func acceptsJSON(accept string) bool {
+	best, isJSON := 0.0, false
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, _ := strings.Cut(part, ";")
+		mt = strings.TrimSpace(mt)
+		if mt == "" {
+			continue
+		}
+		if q := qValue(params); q > best {
+			best, isJSON = q, strings.EqualFold(mt, ContentTypeJSON)
+		}
+	}
+	return isJSON
+}
+
+// qValue returns the q parameter of the media range parameters params,
+// 1 if there is none and 0 if it is invalid
+func qValue(params string) float64 {
+	for _, p := range strings.Split(params, ";") {
+		k, v, _ := strings.Cut(p, "=")
+		if !strings.EqualFold(strings.TrimSpace(k), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0
+		}
+		return q
+	}
+	return 1
+}
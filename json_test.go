@@ -0,0 +1,101 @@
+package promnats
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_acceptsJSON(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"text/html", false},
+		{"application/json", true},
+		{"text/plain;version=0.0.4, application/json;q=0.5", false},
+		{"text/plain;q=0.5, application/json", true},
+		{"application/vnd.google.protobuf;q=1, application/json;q=0.1", false},
+		{"application/json;q=0.9, text/plain;q=0.9", true},
+		{"text/plain;q=0.9, application/json;q=0.9", false},
+		{"application/json;q=0", false},
+		{"application/json;q=bad", false},
+		{"application/json; Q=0.8, */*;q=0.1", true},
+		{"application/jsonx", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			if got := acceptsJSON(tt.accept); got != tt.want {
+				t.Errorf("acceptsJSON() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONFloat_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{1.5, "1.5"},
+		{math.NaN(), `"NaN"`},
+		{math.Inf(1), `"+Inf"`},
+		{math.Inf(-1), `"-Inf"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			got, err := json.Marshal(JSONFloat(tt.v))
+			if err != nil {
+				t.Fatalf("MarshalJSON() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("MarshalJSON() = %s, want %s", got, tt.want)
+			}
+			var back JSONFloat
+			if err := json.Unmarshal(got, &back); err != nil {
+				t.Fatalf("UnmarshalJSON() error = %v", err)
+			}
+			if float64(back) != tt.v && !math.IsNaN(tt.v) {
+				t.Errorf("UnmarshalJSON() = %v, want %v", back, tt.v)
+			}
+		})
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "c_total", Help: "a counter"}, []string{"l"})
+	c.WithLabelValues("x").Add(2)
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "h", Buckets: []float64{1}})
+	h.Observe(0.5)
+	h.Observe(3)
+	reg.MustRegister(c, h)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeJSON(&buf, mfs); err != nil {
+		t.Fatalf("EncodeJSON() error = %v", err)
+	}
+	got := []JSONFamily{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("EncodeJSON() = %d families, want 2", len(got))
+	}
+	cm := got[0].Metrics[0]
+	if got[0].Type != "counter" || cm.Labels["l"] != "x" || *cm.Value != 2 {
+		t.Errorf("EncodeJSON() counter = %+v", got[0])
+	}
+	hm := got[1].Metrics[0].Histogram
+	if got[1].Type != "histogram" || hm.Count != 2 || len(hm.Buckets) != 2 || hm.Buckets[0].Count != 1 || !math.IsInf(float64(hm.Buckets[1].UpperBound), 1) {
+		t.Errorf("EncodeJSON() histogram = %+v", hm)
+	}
+}
@@ -0,0 +1,75 @@
+package promnats
+
+import (
+	"github.com/nats-io/nats.go/micro"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// microCollector collects the endpoint stats of a micro.Service
+type microCollector struct {
+	svc micro.Service
+
+	info          *prometheus.Desc
+	started       *prometheus.Desc
+	requests      *prometheus.Desc
+	errors        *prometheus.Desc
+	processing    *prometheus.Desc
+	avgProcessing *prometheus.Desc
+	lastError     *prometheus.Desc
+}
+
+// NewMicroCollector returns a collector for the endpoint stats of svc,
+// the same numbers that are reported on $SRV.STATS.
+// The stats are counters that start over when the service is reset.
+func NewMicroCollector(svc micro.Service) prometheus.Collector {
+	endpoint := []string{"service", "endpoint", "subject"}
+	desc := func(name, help string, variable ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("nats", "micro", name), help, variable, nil)
+	}
+	return &microCollector{
+		svc:           svc,
+		info:          desc("service_info", "Identity of the service.", "service", "id", "version"),
+		started:       desc("service_started_timestamp_seconds", "Time the service was started.", "service"),
+		requests:      desc("requests_total", "Number of requests to the endpoint.", endpoint...),
+		errors:        desc("errors_total", "Number of requests to the endpoint that failed.", endpoint...),
+		processing:    desc("processing_seconds_total", "Time spent processing requests to the endpoint.", endpoint...),
+		avgProcessing: desc("average_processing_seconds", "Average time spent processing a request to the endpoint.", endpoint...),
+		lastError:     desc("last_error_info", "The last error of the endpoint, if any.", append(endpoint, "error")...),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *microCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.info
+	ch <- c.started
+	ch <- c.requests
+	ch <- c.errors
+	ch <- c.processing
+	ch <- c.avgProcessing
+	ch <- c.lastError
+}
+
+// Collect implements prometheus.Collector
+func (c *microCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.svc.Stats()
+	ch <- prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1, stats.Name, stats.ID, stats.Version)
+	ch <- prometheus.MustNewConstMetric(c.started, prometheus.GaugeValue, float64(stats.Started.UnixNano())/1e9, stats.Name)
+	for _, e := range stats.Endpoints {
+		ch <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(e.NumRequests), stats.Name, e.Name, e.Subject)
+		ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(e.NumErrors), stats.Name, e.Name, e.Subject)
+		ch <- prometheus.MustNewConstMetric(c.processing, prometheus.CounterValue, e.ProcessingTime.Seconds(), stats.Name, e.Name, e.Subject)
+		ch <- prometheus.MustNewConstMetric(c.avgProcessing, prometheus.GaugeValue, e.AverageProcessingTime.Seconds(), stats.Name, e.Name, e.Subject)
+		if e.LastError != "" {
+			ch <- prometheus.MustNewConstMetric(c.lastError, prometheus.GaugeValue, 1, stats.Name, e.Name, e.Subject, e.LastError)
+		}
+	}
+}
+
+// WithMicroService adds the endpoint stats of svc to the gathered metrics,
+// see NewMicroCollector.
+func WithMicroService(svc micro.Service) Option {
+	return func(o *options) error {
+		o.collectors = append(o.collectors, NewMicroCollector(svc))
+		return nil
+	}
+}
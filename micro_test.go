@@ -0,0 +1,54 @@
+package promnats
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// statsService only implements Stats
+type statsService struct {
+	micro.Service
+	stats micro.Stats
+}
+
+func (s statsService) Stats() micro.Stats { return s.stats }
+
+func TestNewMicroCollector(t *testing.T) {
+	svc := statsService{stats: micro.Stats{
+		ServiceIdentity: micro.ServiceIdentity{Name: "orders", ID: "abc", Version: "1.0.0"},
+		Endpoints: []*micro.EndpointStats{
+			{Name: "create", Subject: "orders.create", NumRequests: 10, NumErrors: 2, LastError: "500:boom", ProcessingTime: 2 * time.Second},
+			{Name: "list", Subject: "orders.list", NumRequests: 3},
+		},
+	}}
+	want := `
+# HELP nats_micro_requests_total Number of requests to the endpoint.
+# TYPE nats_micro_requests_total counter
+nats_micro_requests_total{endpoint="create",service="orders",subject="orders.create"} 10
+nats_micro_requests_total{endpoint="list",service="orders",subject="orders.list"} 3
+# HELP nats_micro_errors_total Number of requests to the endpoint that failed.
+# TYPE nats_micro_errors_total counter
+nats_micro_errors_total{endpoint="create",service="orders",subject="orders.create"} 2
+nats_micro_errors_total{endpoint="list",service="orders",subject="orders.list"} 0
+# HELP nats_micro_processing_seconds_total Time spent processing requests to the endpoint.
+# TYPE nats_micro_processing_seconds_total counter
+nats_micro_processing_seconds_total{endpoint="create",service="orders",subject="orders.create"} 2
+nats_micro_processing_seconds_total{endpoint="list",service="orders",subject="orders.list"} 0
+# HELP nats_micro_last_error_info The last error of the endpoint, if any.
+# TYPE nats_micro_last_error_info gauge
+nats_micro_last_error_info{endpoint="create",error="500:boom",service="orders",subject="orders.create"} 1
+# HELP nats_micro_service_info Identity of the service.
+# TYPE nats_micro_service_info gauge
+nats_micro_service_info{id="abc",service="orders",version="1.0.0"} 1
+`
+	err := testutil.CollectAndCompare(NewMicroCollector(svc), strings.NewReader(want),
+		"nats_micro_requests_total", "nats_micro_errors_total", "nats_micro_processing_seconds_total",
+		"nats_micro_last_error_info", "nats_micro_service_info")
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,77 @@
+package promnats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	// HeaderInclude is a comma separated list of on demand tiers to gather
+	// in addition to the default registry.
+	HeaderInclude = "Promnats-Include"
+	// HeaderTiers is a comma separated list of the on demand tiers an instance has
+	HeaderTiers = "Promnats-Tiers"
+)
+
+// WithOnDemandCollector registers c in tier. Collectors in a tier are only
+// gathered when the tier is listed in the HeaderInclude header of the request.
+func WithOnDemandCollector(tier string, c prometheus.Collector) Option {
+	return func(o *options) error {
+		if tier == "" || strings.ContainsAny(tier, ", ") {
+			return fmt.Errorf("invalid tier '%s'", tier)
+		}
+		if o.Tiers == nil {
+			o.Tiers = make(map[string]*prometheus.Registry)
+		}
+		reg, ok := o.Tiers[tier]
+		if !ok {
+			reg = prometheus.NewRegistry()
+			o.Tiers[tier] = reg
+		}
+		return reg.Register(c)
+	}
+}
+
+// tierNames returns the sorted names of tiers
+func tierNames(tiers map[string]*prometheus.Registry) []string {
+	out := make([]string, 0, len(tiers))
+	for name := range tiers {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// includeTiers returns the gatherers of the tiers asked for in h
+func includeTiers(h nats.Header, tiers map[string]*prometheus.Registry) prometheus.Gatherers {
+	var out prometheus.Gatherers
+	for _, name := range strings.Split(h.Get(HeaderInclude), ",") {
+		if reg, ok := tiers[strings.TrimSpace(name)]; ok {
+			out = append(out, reg)
+		}
+	}
+	return out
+}
+
+// tieredGatherer gathers from base and then merges the families of extra
+type tieredGatherer struct {
+	base  prometheus.TransactionalGatherer
+	extra prometheus.Gatherers
+}
+
+func (g *tieredGatherer) Gather() ([]*dto.MetricFamily, func(), error) {
+	mfs, done, err := g.base.Gather()
+	if err != nil {
+		return mfs, done, err
+	}
+	gathered := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return mfs, nil
+	})
+	merged, err := append(prometheus.Gatherers{gathered}, g.extra...).Gather()
+	return merged, done, err
+}
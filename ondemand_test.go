@@ -0,0 +1,46 @@
+package promnats
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWithOnDemandCollector(t *testing.T) {
+	base := prometheus.NewRegistry()
+	base.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "base"}))
+	cfg := &options{}
+	if err := WithOnDemandCollector("deep", prometheus.NewGauge(prometheus.GaugeOpts{Name: "deep"}))(cfg); err != nil {
+		t.Fatalf("WithOnDemandCollector() error = %v", err)
+	}
+	if err := WithOnDemandCollector("a,b", prometheus.NewGauge(prometheus.GaugeOpts{Name: "x"}))(cfg); err == nil {
+		t.Errorf("WithOnDemandCollector() invalid tier, want error")
+	}
+
+	tests := []struct {
+		name    string
+		include string
+		want    []string
+	}{
+		{"none", "", []string{"base"}},
+		{"unknown", "other", []string{"base"}},
+		{"deep", "other, deep", []string{"base", "deep"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reg prometheus.TransactionalGatherer = prometheus.ToTransactionalGatherer(base)
+			if extra := includeTiers(nats.Header{HeaderInclude: {tt.include}}, cfg.Tiers); len(extra) > 0 {
+				reg = &tieredGatherer{base: reg, extra: extra}
+			}
+			mfs, done, err := reg.Gather()
+			if err != nil {
+				t.Fatalf("Gather() error = %v", err)
+			}
+			defer done()
+			if got := names(mfs); len(got) != len(tt.want) || got[0] != tt.want[0] || got[len(got)-1] != tt.want[len(tt.want)-1] {
+				t.Errorf("Gather() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+// Package otelbridge serves the metrics of an OpenTelemetry MeterProvider
+// together with the client_golang ones. It is a separate package so only
+// those using it compile the OTel SDK.
+package otelbridge
+
+import (
+	"github.com/kmpm/promnats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// Reader is a reader for an OpenTelemetry MeterProvider whose metrics
+// are served together with the client_golang ones by WithReader.
+type Reader struct {
+	sdkmetric.Reader
+	reg *prometheus.Registry
+}
+
+// NewReader returns a reader using the OTel Prometheus exporter with opts.
+// Resource attributes are sent as a target_info family, use
+// otelprom.WithResourceAsConstantLabels to add them as labels to every metric instead.
+//
+//	reader, err := otelbridge.NewReader()
+//	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithResource(res))
+//	promnats.RequestHandler(nc, otelbridge.WithReader(reader))
+func NewReader(opts ...otelprom.Option) (*Reader, error) {
+	reg := prometheus.NewRegistry()
+	exp, err := otelprom.New(append(opts, otelprom.WithRegisterer(reg))...)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{Reader: exp, reg: reg}, nil
+}
+
+// Gatherer returns the gatherer of the metrics read by r
+func (r *Reader) Gatherer() prometheus.Gatherer {
+	return r.reg
+}
+
+// WithReader merges the metrics of the MeterProvider using reader
+// into the replies.
+func WithReader(reader *Reader) promnats.Option {
+	if reader == nil {
+		return promnats.WithGatherer(nil)
+	}
+	return promnats.WithGatherer(reader.reg)
+}
@@ -0,0 +1,103 @@
+package promnats
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// maxPooledBuffer is the largest buffer put back in the pool,
+// larger ones are left to the garbage collector
+const maxPooledBuffer = 8 << 20
+
+// encodeBuffer is a reply buffer with encoders writing to it
+type encodeBuffer struct {
+	bytes.Buffer
+	encoders map[expfmt.Format]expfmt.Encoder
+	json     *json.Encoder
+}
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		b := &encodeBuffer{encoders: map[expfmt.Format]expfmt.Encoder{}}
+		b.json = json.NewEncoder(&b.Buffer)
+		return b
+	},
+}
+
+// getBuffer returns an empty buffer from the pool
+func getBuffer() *encodeBuffer {
+	return bufferPool.Get().(*encodeBuffer)
+}
+
+// putBuffer returns b to the pool. The bytes of b must not be used afterwards.
+func putBuffer(b *encodeBuffer) {
+	if b.Cap() > maxPooledBuffer {
+		return
+	}
+	b.Reset()
+	bufferPool.Put(b)
+}
+
+// encoder returns the encoder for format writing to b.
+// The expfmt encoders keep no state between families, so they are reused.
+func (b *encodeBuffer) encoder(format expfmt.Format) expfmt.Encoder {
+	enc, ok := b.encoders[format]
+	if !ok {
+		enc = expfmt.NewEncoder(&b.Buffer, format)
+		b.encoders[format] = enc
+	}
+	return enc
+}
+
+// WithGatherCache reuses the gathered families for ttl instead of
+// gathering for every request. Useful when many requesters scrape the
+// same instance or collecting is expensive. On demand tiers are not cached.
+func WithGatherCache(ttl time.Duration) Option {
+	return func(o *options) error {
+		if ttl <= 0 {
+			return errors.New("cache ttl must be positive")
+		}
+		o.CacheTTL = ttl
+		return nil
+	}
+}
+
+// cachedGatherer gathers from g at most once per ttl. The cached families
+// are shared between requests and must not be modified.
+type cachedGatherer struct {
+	g   prometheus.TransactionalGatherer
+	ttl time.Duration
+	now func() time.Time
+
+	mu  sync.Mutex
+	mfs []*dto.MetricFamily
+	at  time.Time
+}
+
+func newCachedGatherer(g prometheus.TransactionalGatherer, ttl time.Duration) *cachedGatherer {
+	return &cachedGatherer{g: g, ttl: ttl, now: time.Now}
+}
+
+func (c *cachedGatherer) Gather() ([]*dto.MetricFamily, func(), error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if c.mfs != nil && now.Sub(c.at) < c.ttl {
+		return c.mfs, func() {}, nil
+	}
+	mfs, done, err := c.g.Gather()
+	// done releases nothing for the gatherers used here, the families stay valid
+	done()
+	if err != nil {
+		return mfs, func() {}, err
+	}
+	c.mfs, c.at = mfs, now
+	return mfs, func() {}, nil
+}
@@ -0,0 +1,110 @@
+package promnats
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func Test_cachedGatherer(t *testing.T) {
+	calls := 0
+	var fail error
+	g := newCachedGatherer(prometheus.ToTransactionalGatherer(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		calls++
+		return []*dto.MetricFamily{gauge("a", float64(calls))}, fail
+	})), 10*time.Second)
+	now := time.Unix(1000, 0)
+	g.now = func() time.Time { return now }
+
+	tests := []struct {
+		name      string
+		advance   time.Duration
+		fail      error
+		wantCalls int
+		wantValue float64
+	}{
+		{"first gathers", 0, nil, 1, 1},
+		{"within ttl", 5 * time.Second, nil, 1, 1},
+		{"expired", 5 * time.Second, nil, 2, 2},
+		{"error not cached", 10 * time.Second, errors.New("boom"), 3, 3},
+		{"after error", 0, nil, 4, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+			fail = tt.fail
+			mfs, done, err := g.Gather()
+			done()
+			if (err != nil) != (tt.fail != nil) {
+				t.Fatalf("Gather() error = %v, want %v", err, tt.fail)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("Gather() calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if got := mfs[0].Metric[0].Gauge.GetValue(); got != tt.wantValue {
+				t.Errorf("Gather() value = %v, want %v", got, tt.wantValue)
+			}
+		})
+	}
+}
+
+func Test_encode_reused(t *testing.T) {
+	mfs := []*dto.MetricFamily{series("a", 3), gauge("b", 2)}
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{"text", "text/plain;version=0.0.4", "text/plain"},
+		{"protodelim", string(expfmt.NewFormat(expfmt.TypeProtoDelim)), "application/vnd.google.protobuf"},
+		{"json", ContentTypeJSON, ContentTypeJSON},
+	}
+	b := getBuffer()
+	defer putBuffer(b)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := nats.Header{}
+			h.Set(hdrAccept, tt.accept)
+			var first []byte
+			for i := 0; i < 2; i++ {
+				b.Reset()
+				got, err := encode(b, mfs, h)
+				if err != nil {
+					t.Fatalf("encode() error = %v", err)
+				}
+				if !strings.HasPrefix(got, tt.want) {
+					t.Errorf("encode() content type = %v, want %v", got, tt.want)
+				}
+				if i == 0 {
+					first = bytes.Clone(b.Bytes())
+				} else if !bytes.Equal(first, b.Bytes()) {
+					t.Errorf("encode() with reused encoder = %q, want %q", b.Bytes(), first)
+				}
+			}
+		})
+	}
+}
+
+func TestWithGatherCache(t *testing.T) {
+	if err := WithGatherCache(0)(&options{}); err == nil {
+		t.Error("WithGatherCache(0) error = nil, want error")
+	}
+	cfg := &options{}
+	if err := WithGatherCache(time.Second)(cfg); err != nil {
+		t.Fatalf("WithGatherCache() error = %v", err)
+	}
+	g, err := gatherer(nil, cfg)
+	if err != nil {
+		t.Fatalf("gatherer() error = %v", err)
+	}
+	if _, ok := g.(*cachedGatherer); !ok {
+		t.Errorf("gatherer() = %T, want *cachedGatherer", g)
+	}
+}
@@ -0,0 +1,173 @@
+package promnats
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// HeaderSeconds is the duration of a cpu profile or trace, same as the
+	// seconds parameter of net/http/pprof.
+	HeaderSeconds = "Promnats-Seconds"
+	// HeaderDebug selects the debug (text) format of a profile.
+	HeaderDebug = "Promnats-Debug"
+	// HeaderGC runs a garbage collection before taking a heap profile.
+	HeaderGC = "Promnats-GC"
+	// HeaderChunk is the zero based index of a chunk in a chunked reply.
+	HeaderChunk = "Promnats-Chunk"
+	// HeaderChunks is the total number of chunks in a chunked reply.
+	HeaderChunks = "Promnats-Chunks"
+
+	// DefaultPprofMaxDuration is the longest cpu profile or trace
+	// that will be taken if nothing else is configured.
+	DefaultPprofMaxDuration = 60 * time.Second
+
+	pprofToken = "pprof"
+	// headroom left for headers when splitting replies into chunks
+	chunkHeadroom = 4 * 1024
+)
+
+// WithPprof will answer on <root>.<id>.pprof.<profile> with runtime/pprof data.
+// Cpu profiles and traces are bounded by maxDuration,
+// DefaultPprofMaxDuration is used if maxDuration <= 0.
+func WithPprof(maxDuration time.Duration) Option {
+	return func(o *options) error {
+		if maxDuration <= 0 {
+			maxDuration = DefaultPprofMaxDuration
+		}
+		o.Pprof = true
+		o.PprofMax = maxDuration
+		return nil
+	}
+}
+
+// PprofSubject returns the subject that answers with profile for id.
+func PprofSubject(root, id, profile string) string {
+	return strings.Join([]string{root, id, pprofToken, profile}, ".")
+}
+
+func handlePprof(nc *nats.Conn, msg *nats.Msg, cfg *options) error {
+	profile := msg.Subject[strings.LastIndex(msg.Subject, ".")+1:]
+	seconds := headerInt(msg.Header, HeaderSeconds, 0)
+	debug := headerInt(msg.Header, HeaderDebug, 0)
+	gc := headerInt(msg.Header, HeaderGC, 0) > 0
+
+	var buf bytes.Buffer
+	contentType, err := writeProfile(&buf, profile, seconds, cfg.PprofMax, debug, gc)
+	if err != nil {
+		return respondStatus(msg, cfg.Header, err)
+	}
+
+	hdr := copyHeader(cfg.Header)
+	hdr.Set("Content-Type", contentType)
+	return respondChunked(nc, msg, hdr, buf.Bytes())
+}
+
+// writeProfile writes profile to w and returns the content type of the written data.
+func writeProfile(w io.Writer, profile string, seconds int, max time.Duration, debug int, gc bool) (string, error) {
+	contentType := "application/octet-stream"
+	switch profile {
+	case "profile":
+		if err := pprof.StartCPUProfile(w); err != nil {
+			return "", &statusError{code: 500, description: err.Error()}
+		}
+		time.Sleep(profileDuration(seconds, 30, max))
+		pprof.StopCPUProfile()
+	case "trace":
+		if err := trace.Start(w); err != nil {
+			return "", &statusError{code: 500, description: err.Error()}
+		}
+		time.Sleep(profileDuration(seconds, 1, max))
+		trace.Stop()
+	default:
+		p := pprof.Lookup(profile)
+		if p == nil {
+			return "", &statusError{code: 404, description: fmt.Sprintf("unknown profile %q", profile)}
+		}
+		if profile == "heap" && gc {
+			runtime.GC()
+		}
+		if debug > 0 {
+			contentType = "text/plain; charset=utf-8"
+		}
+		if err := p.WriteTo(w, debug); err != nil {
+			return "", &statusError{code: 500, description: err.Error()}
+		}
+	}
+	return contentType, nil
+}
+
+// profileDuration returns seconds, or def if not set, capped at max
+func profileDuration(seconds, def int, max time.Duration) time.Duration {
+	if seconds <= 0 {
+		seconds = def
+	}
+	d := time.Duration(seconds) * time.Second
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// respondChunked replies with data split into chunks that fit the max payload of nc.
+// Every chunk carries HeaderChunk and HeaderChunks.
+func respondChunked(nc *nats.Conn, msg *nats.Msg, hdr nats.Header, data []byte) error {
+	size := int(nc.MaxPayload()) - chunkHeadroom
+	if size < chunkHeadroom {
+		size = chunkHeadroom
+	}
+	chunks := chunk(data, size)
+	if len(chunks) > 1 && !ParseCapabilities(msg.Header).Has(CapChunking) {
+		// plain requesters only see the first message of a chunked reply
+		return respondStatus(msg, hdr, &statusError{code: 413, description: "reply too large and requester does not support chunking"})
+	}
+	for i, c := range chunks {
+		resp := nats.NewMsg(msg.Subject)
+		resp.Header = copyHeader(hdr)
+		resp.Header.Set(HeaderChunk, strconv.Itoa(i))
+		resp.Header.Set(HeaderChunks, strconv.Itoa(len(chunks)))
+		resp.Data = c
+		if err := msg.RespondMsg(resp); err != nil {
+			slog.Error("error sending chunk", "err", err, "chunk", i)
+			return err
+		}
+	}
+	return nil
+}
+
+// chunk splits data into pieces of at most size bytes.
+// It always returns at least one, possibly empty, chunk.
+func chunk(data []byte, size int) [][]byte {
+	out := [][]byte{}
+	for len(data) > size {
+		out = append(out, data[:size])
+		data = data[size:]
+	}
+	return append(out, data)
+}
+
+func headerInt(h nats.Header, key string, def int) int {
+	v, err := strconv.Atoi(h.Get(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func copyHeader(h nats.Header) nats.Header {
+	out := nats.Header{}
+	for k, v := range h {
+		out[k] = append([]string(nil), v...)
+	}
+	return out
+}
@@ -0,0 +1,144 @@
+package promnats
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func Test_chunk(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		size int
+		want []int
+	}{
+		{"empty", []byte{}, 4, []int{0}},
+		{"exact", []byte("abcd"), 4, []int{4}},
+		{"split", []byte("abcdefghij"), 4, []int{4, 4, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := chunk(tt.data, tt.size)
+			if len(got) != len(tt.want) {
+				t.Fatalf("chunk() = %d chunks, want %d", len(got), len(tt.want))
+			}
+			for i, c := range got {
+				if len(c) != tt.want[i] {
+					t.Errorf("chunk()[%d] = %d bytes, want %d", i, len(c), tt.want[i])
+				}
+			}
+			if !bytes.Equal(bytes.Join(got, nil), tt.data) {
+				t.Errorf("chunk() joined = %q, want %q", bytes.Join(got, nil), tt.data)
+			}
+		})
+	}
+}
+
+func Test_profileDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		seconds int
+		def     int
+		max     time.Duration
+		want    time.Duration
+	}{
+		{"default", 0, 30, time.Minute, 30 * time.Second},
+		{"given", 5, 30, time.Minute, 5 * time.Second},
+		{"capped", 120, 30, time.Minute, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := profileDuration(tt.seconds, tt.def, tt.max); got != tt.want {
+				t.Errorf("profileDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_writeProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		debug   int
+		wantCT  string
+		wantErr bool
+	}{
+		{"heap", "heap", 0, "application/octet-stream", false},
+		{"goroutine text", "goroutine", 1, "text/plain; charset=utf-8", false},
+		{"unknown", "nope", 0, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			ct, err := writeProfile(&buf, tt.profile, 0, time.Second, tt.debug, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeProfile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ct != tt.wantCT {
+				t.Errorf("writeProfile() content type = %v, want %v", ct, tt.wantCT)
+			}
+			if !tt.wantErr && buf.Len() == 0 {
+				t.Errorf("writeProfile() wrote nothing")
+			}
+		})
+	}
+}
+
+func Test_respondChunked(t *testing.T) {
+	s := runServer(t)
+	nc := connect(t, s)
+	// larger than the max payload, so it takes two chunks
+	data := bytes.Repeat([]byte("x"), int(nc.MaxPayload())+1)
+	sub, err := nc.Subscribe("profile", func(msg *nats.Msg) {
+		respondChunked(nc, msg, nats.Header{}, data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	tests := []struct {
+		name       string
+		caps       string
+		wantStatus string
+		wantChunks int
+	}{
+		{"chunking", "chunking,json", "", 2},
+		{"no capabilities", "", "413", 0},
+		{"without chunking", "json", "413", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inbox, err := nc.SubscribeSync(nc.NewRespInbox())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer inbox.Unsubscribe()
+			msg := nats.NewMsg("profile")
+			msg.Reply = inbox.Subject
+			if tt.caps != "" {
+				msg.Header.Set(HeaderCapabilities, tt.caps)
+			}
+			if err := nc.PublishMsg(msg); err != nil {
+				t.Fatal(err)
+			}
+			var got []byte
+			for i := 0; i < max(tt.wantChunks, 1); i++ {
+				m, err := inbox.NextMsg(2 * time.Second)
+				if err != nil {
+					t.Fatalf("NextMsg() error = %v", err)
+				}
+				if status := m.Header.Get("Status"); status != tt.wantStatus {
+					t.Fatalf("Status = %q, want %q", status, tt.wantStatus)
+				}
+				got = append(got, m.Data...)
+			}
+			if tt.wantChunks > 0 && !bytes.Equal(got, data) {
+				t.Errorf("joined %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
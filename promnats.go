@@ -1,7 +1,6 @@
 package promnats
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -14,21 +13,51 @@ import (
 
 	"github.com/nats-io/nats.go"
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 )
 
 const (
 	hdrAccept  = "Accept"
 	HeaderPnID = "Promnats-ID"
+
+	discoverToken = "$discover"
 )
 
 type options struct {
 	RootSubject string
 	Header      nats.Header
 	Subjects    []string
-	Subs        []*nats.Subscription
 	Debug       bool
 	ID          string
+	Pprof       bool
+	PprofMax    time.Duration
+	Metadata    map[string]string
+	Version     string
+	HTTPHandler http.Handler
+	Tiers       map[string]*prometheus.Registry
+	Jitter      time.Duration
+
+	ScrapeInterval time.Duration
+	ScrapeTimeout  time.Duration
+
+	Collision    CollisionPolicy
+	ProbeTimeout time.Duration
+
+	PushBucket string
+
+	CacheTTL time.Duration
+
+	delta   *deltaTracker
+	limiter *windowLimiter
+	limits  *cardinalityLimits
+	auth    *authPolicy
+	labels  map[string]string
+
+	connCollector bool
+	connLabels    prometheus.Labels
+	collectors    []prometheus.Collector
+	gatherers     prometheus.Gatherers
 }
 
 type Option func(*options) error
@@ -91,6 +120,18 @@ func WithID(id string) Option {
 	return WithParts(strings.Split(id, ".")...)
 }
 
+// WithGatherer merges the metrics of g into the replies, like those of a
+// bridge from another metrics library such as otelbridge.
+func WithGatherer(g prometheus.Gatherer) Option {
+	return func(o *options) error {
+		if g == nil {
+			return errors.New("gatherer must not be nil")
+		}
+		o.gatherers = append(o.gatherers, g)
+		return nil
+	}
+}
+
 func WithDebug() Option {
 	return func(o *options) error {
 		o.Debug = true
@@ -110,67 +151,36 @@ func defaultSubjects() []string {
 	}
 }
 
-func genID(s []string) string {
-	return strings.ToLower(s[len(s)-1])
+// DiscoverSubject returns the subject where all instances answer
+// with their headers only, without gathering any metrics.
+func DiscoverSubject(root string) string {
+	return root + "." + discoverToken
 }
 
-func RequestHandler(nc *nats.Conn, opts ...Option) error {
-	//default
-	cfg := options{
-		RootSubject: "metrics",
-		Header:      nats.Header{},
-	}
-
-	for _, o := range opts {
-		err := o(&cfg)
-		if err != nil {
-			return err
-		}
+// subject returns the full subject for a configured subject part
+func (o *options) subject(s string) string {
+	if s == "" {
+		return o.RootSubject
 	}
-	if len(cfg.Subjects) == 0 {
-		cfg.Subjects = defaultSubjects()
-	}
-	cfg.ID = genID(cfg.Subjects)
-	cfg.Header.Add(HeaderPnID, cfg.ID)
-
-	reg := prometheus.ToTransactionalGatherer(prometheus.DefaultGatherer)
+	return fmt.Sprintf("%s.%s", o.RootSubject, strings.ToLower(s))
+}
 
-	handle := func(msg *nats.Msg) {
-		err := handleMsg(msg, &cfg, reg)
-		if err != nil {
-			//TODO: notify shomehow
-			if cfg.Debug {
-				slog.Debug("error handling message", "err", err)
-			}
-		}
-	}
+func genID(s []string) string {
+	return strings.ToLower(s[len(s)-1])
+}
 
-	unsub := func() {
-		for _, sub := range cfg.Subs {
-			sub.Unsubscribe()
-		}
-	}
-	if cfg.Debug {
-		slog.Debug("configured subjects", "subjects", cfg.Subjects)
-	}
-	for _, subj := range cfg.Subjects {
-		if subj != "" {
-			subj = fmt.Sprintf("%s.%s", cfg.RootSubject, strings.ToLower(subj))
-		} else {
-			subj = cfg.RootSubject
-		}
-		sub, err := nc.Subscribe(subj, handle)
-		if err != nil {
-			unsub()
-			return err
-		}
-		cfg.Subs = append(cfg.Subs, sub)
-		if cfg.Debug {
-			slog.Debug("subscribing to", "subject", subj)
-		}
-	}
+// RequestHandler answers metrics requests on nc until the connection is closed.
+// Use NewHandler to be able to change the ID or stop answering.
+func RequestHandler(nc *nats.Conn, opts ...Option) error {
+	_, err := NewHandler(nc, opts...)
+	return err
+}
 
-	return nil
+// RequestHandlerMulti answers metrics requests on every connection in ncs,
+// see NewHandlerMulti.
+func RequestHandlerMulti(ncs []*nats.Conn, opts ...Option) error {
+	_, err := NewHandlerMulti(ncs, opts...)
+	return err
 }
 
 func handleMsg(msg *nats.Msg, cfg *options, reg prometheus.TransactionalGatherer) error {
@@ -180,45 +190,84 @@ func handleMsg(msg *nats.Msg, cfg *options, reg prometheus.TransactionalGatherer
 			slog.Debug("promnats response time", "time", time.Since(start))
 		}()
 	}
-	mfs, done, err := reg.Gather()
+	resp, buf, se := gatherReply(msg.Header, cfg, reg)
+	if se != nil {
+		return respondStatus(msg, cfg.Header, se)
+	}
+	// the reply is copied to the outgoing buffer of the connection when sent
+	defer putBuffer(buf)
+	resp.Subject = msg.Subject
+
+	err := msg.RespondMsg(resp)
 	if err != nil {
-		return err
+		//log error
+		slog.Error("error sending reply", "err", err)
 	}
-	defer done()
 
-	contentType := negotiate(msg.Header)
-	var buf bytes.Buffer
-	enc := expfmt.NewEncoder(&buf, contentType)
+	return nil
+}
 
-	for _, mf := range mfs {
-		err = enc.Encode(mf)
-		if err != nil {
-			return err
-		}
+// gatherReply gathers and encodes the reply to a request with the header req.
+// The data of resp is owned by buf, which must be returned with putBuffer
+// once resp is sent.
+func gatherReply(req nats.Header, cfg *options, reg prometheus.TransactionalGatherer) (*nats.Msg, *encodeBuffer, *statusError) {
+	if extra := includeTiers(req, cfg.Tiers); len(extra) > 0 {
+		reg = &tieredGatherer{base: reg, extra: extra}
 	}
-	if closer, ok := enc.(expfmt.Closer); ok {
-		err = closer.Close()
-		if err != nil {
-			return err
-		}
+	mfs, done, err := reg.Gather()
+	defer done()
+	if err != nil {
+		return nil, nil, gatherError(err, len(mfs))
 	}
 
-	resp := nats.NewMsg(msg.Subject)
-	resp.Header = cfg.Header
+	if cfg.limits != nil {
+		mfs = cfg.limits.apply(mfs)
+	}
 
-	resp.Header.Set("Content-Type", string(contentType))
-	// if cfg.Debug {
-	// 	log.Printf("response: %v", resp)
-	// }
-	resp.Data = buf.Bytes()
+	resp := &nats.Msg{Header: copyHeader(cfg.Header)}
 
-	err = msg.RespondMsg(resp)
+	if cfg.delta != nil && req.Get(HeaderDelta) != "" {
+		mfs = cfg.delta.diff(req, mfs, resp.Header)
+	}
+
+	buf := getBuffer()
+	contentType, err := encode(buf, mfs, req)
 	if err != nil {
-		//log error
-		slog.Error("error sending reply", "err", err)
+		putBuffer(buf)
+		return nil, nil, &statusError{code: 500, description: "error encoding metrics: " + err.Error()}
 	}
 
-	return nil
+	resp.Header.Set("Content-Type", contentType)
+	resp.Data = buf.Bytes()
+	return resp, buf, nil
+}
+
+// handleDiscover replies with the instance headers and no body
+func handleDiscover(msg *nats.Msg, cfg *options) error {
+	resp := nats.NewMsg(msg.Subject)
+	resp.Header = copyHeader(cfg.Header)
+	return msg.RespondMsg(resp)
+}
+
+// encode writes mfs to b in the format asked for in h
+// and returns the content type used
+func encode(b *encodeBuffer, mfs []*dto.MetricFamily, h nats.Header) (string, error) {
+	if acceptsJSON(h.Get(hdrAccept)) {
+		return ContentTypeJSON, b.json.Encode(ToJSON(mfs))
+	}
+	contentType := negotiate(h)
+	enc := b.encoder(contentType)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return "", err
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return "", err
+		}
+	}
+	return string(contentType), nil
 }
 
 func negotiate(h nats.Header) expfmt.Format {
@@ -4,6 +4,8 @@ import (
 	"reflect"
 	"regexp"
 	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func Test_WithParts(t *testing.T) {
@@ -80,3 +82,47 @@ func TestDefaultSubject(t *testing.T) {
 		})
 	}
 }
+
+func TestWithGatherer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "bridged_total"}))
+	tests := []struct {
+		name    string
+		g       prometheus.Gatherer
+		want    []string
+		wantErr bool
+	}{
+		{"merged", reg, []string{"go_goroutines", "bridged_total"}, false},
+		{"nil", nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &options{}
+			err := WithGatherer(tt.g)(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WithGatherer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			g, err := gatherer(nil, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mfs, done, err := g.Gather()
+			defer done()
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := map[string]bool{}
+			for _, mf := range mfs {
+				found[mf.GetName()] = true
+			}
+			for _, name := range tt.want {
+				if !found[name] {
+					t.Errorf("missing %s", name)
+				}
+			}
+		})
+	}
+}
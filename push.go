@@ -0,0 +1,112 @@
+package promnats
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+const pushToken = "$push"
+
+// PushSubject returns the subject where metrics for id are pushed
+func PushSubject(root, id string) string {
+	return root + "." + pushToken + "." + id
+}
+
+// WithPushBucket makes PushOnce store the metrics in the JetStream KV bucket
+// instead of sending them to a gateway. The key is the id.
+func WithPushBucket(bucket string) Option {
+	return func(o *options) error {
+		if bucket == "" {
+			return errors.New("bucket must not be empty")
+		}
+		o.PushBucket = bucket
+		return nil
+	}
+}
+
+// PushOnce gathers the metrics, including all on demand tiers, and sends them
+// to PushSubject for batch jobs that exit before they can be scraped.
+// It returns when a gateway acknowledges them, or when they are stored
+// if WithPushBucket is used.
+func PushOnce(ctx context.Context, nc *nats.Conn, id string, opts ...Option) error {
+	cfg := &options{
+		RootSubject: "metrics",
+		Header:      nats.Header{},
+	}
+	for _, o := range opts {
+		if err := o(cfg); err != nil {
+			return err
+		}
+	}
+	if err := WithID(id)(cfg); err != nil {
+		return err
+	}
+	cfg.ID = genID(cfg.Subjects)
+	setHeaders(cfg)
+
+	conns, err := newConns([]*nats.Conn{nc})
+	if err != nil {
+		return err
+	}
+	reg, err := gatherer(conns, cfg)
+	if err != nil {
+		return err
+	}
+	extra := prometheus.Gatherers{}
+	for _, name := range tierNames(cfg.Tiers) {
+		extra = append(extra, cfg.Tiers[name])
+	}
+	mfs, done, err := (&tieredGatherer{base: reg, extra: extra}).Gather()
+	defer done()
+	if err != nil {
+		return err
+	}
+	if cfg.limits != nil {
+		mfs = cfg.limits.apply(mfs)
+	}
+
+	format := expfmt.NewFormat(expfmt.TypeProtoDelim)
+	var buf bytes.Buffer
+	enc := expfmt.NewEncoder(&buf, format)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+
+	if cfg.PushBucket != "" {
+		js, err := jetstream.New(nc)
+		if err != nil {
+			return err
+		}
+		kv, err := js.KeyValue(ctx, cfg.PushBucket)
+		if err != nil {
+			return err
+		}
+		_, err = kv.Put(ctx, cfg.ID, buf.Bytes())
+		return err
+	}
+
+	msg := nats.NewMsg(PushSubject(cfg.RootSubject, cfg.ID))
+	msg.Header = copyHeader(cfg.Header)
+	msg.Header.Set("Content-Type", string(format))
+	msg.Data = buf.Bytes()
+	resp, err := nc.RequestMsgWithContext(ctx, msg)
+	if errors.Is(err, nats.ErrNoResponders) {
+		return fmt.Errorf("no gateway accepts pushes: %w", err)
+	}
+	if err != nil {
+		return err
+	}
+	if status := resp.Header.Get(hdrStatus); status != "" {
+		return fmt.Errorf("push rejected: %s %s", status, resp.Header.Get(hdrDescription))
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package promnats
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestPushSubject(t *testing.T) {
+	if got := PushSubject("metrics", "nightly.etl"); got != "metrics.$push.nightly.etl" {
+		t.Errorf("PushSubject() = %v", got)
+	}
+}
+
+func TestWithPushBucket(t *testing.T) {
+	cfg := &options{}
+	if err := WithPushBucket("")(cfg); err == nil {
+		t.Error("expected error for empty bucket")
+	}
+	if err := WithPushBucket("pushes")(cfg); err != nil || cfg.PushBucket != "pushes" {
+		t.Errorf("WithPushBucket() = %v, %v", cfg.PushBucket, err)
+	}
+}
+
+// pushedNames decodes protodelim families and returns their names
+func pushedNames(t *testing.T, data []byte) map[string]bool {
+	t.Helper()
+	dec := expfmt.NewDecoder(bytes.NewReader(data), expfmt.NewFormat(expfmt.TypeProtoDelim))
+	names := map[string]bool{}
+	for {
+		mf := &dto.MetricFamily{}
+		err := dec.Decode(mf)
+		if errors.Is(err, io.EOF) {
+			return names
+		}
+		if err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		names[mf.GetName()] = true
+	}
+}
+
+// pushTier returns an on demand tier with the push_deep_total counter
+func pushTier() Option {
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "push_deep_total"})
+	c.Inc()
+	return WithOnDemandCollector("deep", c)
+}
+
+func TestPushOnce(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		gateway bool
+		wantErr bool
+		is      error
+	}{
+		{"acked", "", true, false, nil},
+		{"rejected", "413", true, true, nil},
+		{"no gateway", "", false, true, nats.ErrNoResponders},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := runServer(t)
+			nc := connect(t, s)
+			pushed := make(chan *nats.Msg, 1)
+			if tt.gateway {
+				sub, err := nc.Subscribe(PushSubject("metrics", "nightly.etl"), func(msg *nats.Msg) {
+					pushed <- msg
+					resp := nats.NewMsg(msg.Reply)
+					if tt.status != "" {
+						resp.Header.Set(hdrStatus, tt.status)
+						resp.Header.Set(hdrDescription, "too large")
+					}
+					msg.RespondMsg(resp)
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer sub.Unsubscribe()
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			err := PushOnce(ctx, nc, "nightly.etl", pushTier())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PushOnce() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Errorf("PushOnce() error = %v, want %v", err, tt.is)
+			}
+			if !tt.gateway {
+				return
+			}
+			msg := <-pushed
+			if got := msg.Header.Get("Content-Type"); got != string(expfmt.NewFormat(expfmt.TypeProtoDelim)) {
+				t.Errorf("Content-Type = %q", got)
+			}
+			if got := msg.Header.Get(HeaderPnID); got != "nightly.etl" {
+				t.Errorf("%s = %q", HeaderPnID, got)
+			}
+			names := pushedNames(t, msg.Data)
+			// the default gatherer and every on demand tier
+			for _, name := range []string{"go_goroutines", "push_deep_total"} {
+				if !names[name] {
+					t.Errorf("missing %s", name)
+				}
+			}
+		})
+	}
+}
+
+func TestPushOnce_bucket(t *testing.T) {
+	s := runJetStreamServer(t)
+	nc := connect(t, s)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	kv, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: "pushes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PushOnce(ctx, nc, "nightly.etl", pushTier(), WithPushBucket("pushes")); err != nil {
+		t.Fatalf("PushOnce() error = %v", err)
+	}
+	entry, err := kv.Get(ctx, "nightly.etl")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	names := pushedNames(t, entry.Value())
+	for _, name := range []string{"go_goroutines", "push_deep_total"} {
+		if !names[name] {
+			t.Errorf("missing %s", name)
+		}
+	}
+	if err := PushOnce(ctx, nc, "nightly.etl", WithPushBucket("missing")); err == nil {
+		t.Error("PushOnce() expected error for a missing bucket")
+	}
+}
@@ -0,0 +1,139 @@
+package promnats
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// HeaderSelector selects the instances that should answer a request by their labels,
+// like `region=eu,version=~"1\\.4.*"`. Instances that don't match stay silent.
+const HeaderSelector = "Promnats-Selector"
+
+// MatchType is the operator of a Matcher
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher matches the value of a single label.
+// A missing label has the value "".
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+	re    *regexp.Regexp
+}
+
+// Matches returns true if v matches
+func (m *Matcher) Matches(v string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	case MatchNotRegexp:
+		return !m.re.MatchString(v)
+	}
+	return false
+}
+
+// Selector matches when all of its matchers match
+type Selector []*Matcher
+
+// Matches returns true if labels match all matchers
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, m := range s {
+		if !m.Matches(labels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseSelector parses comma separated matchers like `region=eu,version=~"1\\.4.*"`.
+// Values can be quoted as Go strings, unquoted values end at the next comma.
+func ParseSelector(s string) (Selector, error) {
+	sel := Selector{}
+	rest := strings.TrimSpace(s)
+	for rest != "" {
+		i := strings.IndexAny(rest, "=!")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid selector '%s': missing label name", s)
+		}
+		m := &Matcher{Name: strings.TrimSpace(rest[:i])}
+		rest = rest[i:]
+		for _, t := range []MatchType{MatchRegexp, MatchNotRegexp, MatchNotEqual, MatchEqual} {
+			if strings.HasPrefix(rest, string(t)) {
+				m.Type = t
+				break
+			}
+		}
+		if m.Type == "" {
+			return nil, fmt.Errorf("invalid selector '%s': unknown operator", s)
+		}
+		rest = strings.TrimLeft(rest[len(m.Type):], " ")
+
+		if strings.HasPrefix(rest, `"`) {
+			q, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, fmt.Errorf("invalid selector '%s': %w", s, err)
+			}
+			m.Value, _ = strconv.Unquote(q)
+			rest = strings.TrimLeft(rest[len(q):], " ")
+			if rest != "" && !strings.HasPrefix(rest, ",") {
+				return nil, fmt.Errorf("invalid selector '%s': expected ','", s)
+			}
+		} else {
+			v, _, _ := strings.Cut(rest, ",")
+			m.Value = strings.TrimSpace(v)
+			rest = rest[len(v):]
+		}
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, ","))
+
+		if m.Type == MatchRegexp || m.Type == MatchNotRegexp {
+			re, err := regexp.Compile("^(?:" + m.Value + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid selector '%s': %w", s, err)
+			}
+			m.re = re
+		}
+		sel = append(sel, m)
+	}
+	return sel, nil
+}
+
+// selectorLabels returns the labels selectors are matched against,
+// the metadata together with id and version unless set in the metadata.
+func selectorLabels(cfg *options) map[string]string {
+	out := map[string]string{
+		"id":      cfg.ID,
+		"version": buildInfo(cfg).Version,
+	}
+	for k, v := range cfg.Metadata {
+		out[k] = v
+	}
+	return out
+}
+
+// selected returns false if the request has a selector that the instance doesn't match
+func selected(h nats.Header, cfg *options) (bool, error) {
+	s := h.Get(HeaderSelector)
+	if s == "" {
+		return true, nil
+	}
+	sel, err := ParseSelector(s)
+	if err != nil {
+		return false, err
+	}
+	return sel.Matches(cfg.labels), nil
+}
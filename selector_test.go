@@ -0,0 +1,44 @@
+package promnats
+
+import (
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	labels := map[string]string{"region": "eu", "version": "1.4.2", "team": "ops"}
+	tests := []struct {
+		name     string
+		selector string
+		want     bool
+		wantErr  bool
+	}{
+		{"empty", "", true, false},
+		{"equal", "region=eu", true, false},
+		{"not equal", "region!=eu", false, false},
+		{"regexp", `region=eu,version=~1\.4.*`, true, false},
+		{"regexp anchored", `version=~4.*`, false, false},
+		{"not regexp", `version!~1\..*`, false, false},
+		{"quoted", `team="ops", version=~"1\\.4.*"`, true, false},
+		{"quoted comma", `region=~"eu|us{1,2}"`, true, false},
+		{"missing label", "zone=a", false, false},
+		{"missing label not equal", "zone!=a", true, false},
+		{"no name", "=eu", false, true},
+		{"no operator", "region", false, true},
+		{"bad regexp", "region=~(", false, true},
+		{"unterminated", `region="eu`, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := ParseSelector(tt.selector)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSelector() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := sel.Matches(labels); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package promnats
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	hdrStatus      = "Status"
+	hdrDescription = "Description"
+)
+
+// statusError is an error that is sent back to the requester
+// as Status and Description headers, the same way nats reports no responders.
+type statusError struct {
+	code        int
+	description string
+	details     []string
+}
+
+func (e *statusError) Error() string {
+	return strconv.Itoa(e.code) + " " + e.description
+}
+
+// respondStatus replies to msg with the status of err.
+// The body has the details of err, if any.
+func respondStatus(msg *nats.Msg, hdr nats.Header, err error) error {
+	se := &statusError{}
+	if !errors.As(err, &se) {
+		se = &statusError{code: 500, description: err.Error()}
+	}
+	resp := nats.NewMsg(msg.Subject)
+	resp.Header = copyHeader(hdr)
+	resp.Header.Set(hdrStatus, strconv.Itoa(se.code))
+	resp.Header.Set(hdrDescription, se.description)
+	if len(se.details) > 0 {
+		resp.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		resp.Data = []byte(strings.Join(se.details, "\n") + "\n")
+	}
+	if rerr := msg.RespondMsg(resp); rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+// gatherError describes a failed gather. Each error of a prometheus.MultiError
+// is added as a detail together with the number of families that were gathered.
+func gatherError(err error, gathered int) *statusError {
+	var errs prometheus.MultiError
+	if !errors.As(err, &errs) {
+		errs = prometheus.MultiError{err}
+	}
+	se := &statusError{
+		code:        500,
+		description: fmt.Sprintf("error gathering metrics: %d errors, %d families gathered", len(errs), gathered),
+	}
+	for _, e := range errs {
+		se.details = append(se.details, e.Error())
+	}
+	return se
+}
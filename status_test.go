@@ -0,0 +1,29 @@
+package promnats
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_gatherError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		gathered    int
+		description string
+		details     int
+	}{
+		{"single", errors.New("boom"), 0, "error gathering metrics: 1 errors, 0 families gathered", 1},
+		{"multi", prometheus.MultiError{errors.New("a"), errors.New("b")}, 3, "error gathering metrics: 2 errors, 3 families gathered", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := gatherError(tt.err, tt.gathered)
+			if got.code != 500 || got.description != tt.description || len(got.details) != tt.details {
+				t.Errorf("gatherError() = %+v", got)
+			}
+		})
+	}
+}